@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/jongschneider/youtube-project/api/internal/platform/auth"
+	"github.com/jongschneider/youtube-project/api/internal/platform/ratelimit"
+	"github.com/jongschneider/youtube-project/api/internal/platform/user"
+	"github.com/jongschneider/youtube-project/api/internal/platform/web"
+	"github.com/pkg/errors"
+)
+
+const (
+	// resendInterval is how long someone has to wait between asking for verification emails to the same address.
+	resendInterval = time.Minute
+
+	// resendLimit is how many verification emails can be asked for per address within resendWindow.
+	resendLimit  = 5
+	resendWindow = 24 * time.Hour
+)
+
+type resendVerificationResponse struct {
+	web.Response
+}
+
+// sendVerificationEmail emails the user a signed link they can follow to verify their email.
+func (h *Handler) sendVerificationEmail(u user.User) error {
+	token, err := h.auth.NewEmailVerificationToken(strconv.Itoa(u.ID), u.Email, h.emailVerificationTTL)
+	if err != nil {
+		return errors.Wrap(err, "new verification token")
+	}
+
+	// The link goes straight to the API, which verifies the email
+	link := h.mailer.APILink("/email/verify", url.Values{"token": {token}})
+	body := fmt.Sprintf("Please confirm this is your email by following this link within %s:\n\n%s\n\nIf you didn't create an account, you can ignore this email.", h.emailVerificationTTL, link)
+
+	return h.mailer.Send(u.Email, "Verify your email", body)
+}
+
+// VerifyEmail marks a user's email as verified using the token from a verification link
+func (h *Handler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		web.RespondWithCodedError(w, r, http.StatusBadRequest, "missing token", errors.New("verify email: missing token"))
+		return
+	}
+
+	claims, err := h.auth.ParseEmailVerificationToken(token)
+	if err != nil {
+		h.log.WithError(err).Info()
+		web.RespondWithCodedError(w, r, http.StatusBadRequest, "invalid or expired token", errors.Wrap(err, "verify email"))
+		return
+	}
+
+	id, err := claims.UserID()
+	if err != nil {
+		h.log.WithError(err).Info()
+		web.RespondWithCodedError(w, r, http.StatusBadRequest, "invalid or expired token", errors.Wrap(err, "verify email"))
+		return
+	}
+
+	err = h.users.VerifyEmail(r.Context(), id, claims.Email)
+	if err != nil {
+		h.log.WithError(err).Info()
+		switch errors.Cause(err) {
+		case user.ErrEmailChanged, sql.ErrNoRows:
+			web.RespondWithCodedError(w, r, http.StatusBadRequest, "invalid or expired token", errors.Wrap(err, "verify email"))
+		default:
+			h.respondServerError(w, r, err, "verify email")
+		}
+		return
+	}
+
+	web.Respond(w, r, nil, http.StatusNoContent)
+}
+
+// ResendVerification emails a new verification link to the provided email.
+// It responds the same way whether or not the email belongs to a user who still has to verify it.
+func (h *Handler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	// Parse the content of the form
+	err := r.ParseForm()
+	if err != nil {
+		h.log.WithError(err)
+		web.RespondWithCodedError(w, r, http.StatusBadRequest, "bad request", err)
+		return
+	}
+
+	email := r.FormValue("email")
+	if email == "" {
+		web.RespondWithCodedError(w, r, http.StatusBadRequest, "missing email", errors.New("resend verification: missing email"))
+		return
+	}
+
+	wait, err := h.resendRequests.Allow(email)
+	if err != nil {
+		h.log.WithError(err).Info()
+		if errors.Cause(err) == ratelimit.ErrLimited {
+			w.Header().Set("Retry-After", retryAfter(wait))
+			web.RespondWithCodedError(w, r, http.StatusTooManyRequests, "too many attempts", errors.Wrap(err, "resend verification"))
+			return
+		}
+
+		h.respondServerError(w, r, err, "resend verification")
+		return
+	}
+
+	// Look up the user and send the email in the background, so the response
+	// takes the same time and is the same whether or not the email belongs to an unverified user
+	if !h.queueMail(func() { h.resendVerification(email) }) {
+		h.log.WithField("action", "resend verification").Warn("mail queue is full, dropping verification email")
+	}
+
+	web.Respond(w, r, resendVerificationResponse{
+		Response: web.Response{
+			Message: "if the email belongs to an unverified account, a verification link has been sent",
+		},
+	}, http.StatusAccepted)
+}
+
+// resendVerification emails a verification link to the user with the email, if there is one who still has to verify it.
+// It runs after the response has been sent, so failures are only logged.
+func (h *Handler) resendVerification(email string) {
+	log := h.log.WithField("action", "resend verification")
+
+	// Go out to the db and try to get the user associated with the provided email
+	u, err := h.users.GetByEmail(context.Background(), email)
+	if err != nil {
+		// The email was not in the db, so there is nobody to send a link to
+		if err != sql.ErrNoRows {
+			log.WithError(err).Info()
+		}
+		return
+	}
+
+	if u.EmailVerified() {
+		return
+	}
+
+	err = h.sendVerificationEmail(u)
+	if err != nil {
+		log.WithError(err).Info()
+	}
+}
+
+// requireVerifiedEmail responds with an error and returns false if tokens can't be issued to the user until they verify their email.
+func (h *Handler) requireVerifiedEmail(w http.ResponseWriter, r *http.Request, u user.User) bool {
+	if !h.auth.RequireVerifiedEmail() || u.EmailVerified() {
+		return true
+	}
+
+	web.RespondWithCodedError(w, r, http.StatusForbidden, "email not verified", errors.Wrap(auth.ErrNotAuthorized, "email not verified"))
+	return false
+}
@@ -0,0 +1,27 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/jongschneider/youtube-project/api/internal/platform/database"
+	"github.com/jongschneider/youtube-project/api/internal/platform/web"
+	"github.com/pkg/errors"
+)
+
+// statusClientClosedRequest is the status nginx uses for a request that the client closed before it was responded to.
+const statusClientClosedRequest = 499
+
+// respondServerError responds to an error that isn't the client's fault.
+// A query that timed out means the db is struggling, so the client is told to try again later.
+// A query that was canceled means the client went away, so nobody will see the response, but it shows up in the logs.
+func (h *Handler) respondServerError(w http.ResponseWriter, r *http.Request, err error, action string) {
+	switch errors.Cause(err) {
+	case database.ErrTimeout:
+		w.Header().Set("Retry-After", "1")
+		web.RespondWithCodedError(w, r, http.StatusServiceUnavailable, "database timeout", errors.Wrap(err, action))
+	case database.ErrCanceled:
+		web.RespondWithCodedError(w, r, statusClientClosedRequest, "request canceled", errors.Wrap(err, action))
+	default:
+		web.RespondWithCodedError(w, r, http.StatusInternalServerError, "", errors.Wrap(err, action))
+	}
+}
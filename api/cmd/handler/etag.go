@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jongschneider/youtube-project/api/internal/platform/user"
+	"github.com/jongschneider/youtube-project/api/internal/platform/web"
+	"github.com/pkg/errors"
+)
+
+// errPreconditionFailed is the error returned when the If-Match header doesn't match the current version of a user.
+var errPreconditionFailed = errors.New("precondition failed")
+
+// userETag returns the entity tag of the user's current version.
+func userETag(u user.User) string {
+	return fmt.Sprintf(`"%d"`, u.Version)
+}
+
+// ifMatch checks the request's If-Match header against the user's current version (RFC 7232).
+// It returns the version a write to the user should be conditional on: the current version if the header
+// matched, or 0 if there is no header. Otherwise it responds with an error and returns false.
+func (h *Handler) ifMatch(w http.ResponseWriter, r *http.Request, u user.User) (int, bool) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		if h.requireIfMatch {
+			web.RespondWithCodedError(w, r, http.StatusPreconditionRequired, "missing If-Match", errors.Wrap(errPreconditionFailed, "missing If-Match"))
+			return 0, false
+		}
+
+		return 0, true
+	}
+
+	etag := userETag(u)
+	for _, tag := range strings.Split(header, ",") {
+		// Weak tags never match, since If-Match uses the strong comparison
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == etag {
+			return u.Version, true
+		}
+	}
+
+	w.Header().Set("ETag", etag)
+	web.RespondWithCodedError(w, r, http.StatusPreconditionFailed, "user has changed", errPreconditionFailed)
+	return 0, false
+}
+
+// respondWriteError responds to an error from a conditional write to a user.
+func (h *Handler) respondWriteError(w http.ResponseWriter, r *http.Request, err error, action string) {
+	h.log.WithError(err).Info()
+
+	switch errors.Cause(err) {
+	case user.ErrVersionMismatch:
+		// The user changed between checking If-Match and writing
+		web.RespondWithCodedError(w, r, http.StatusPreconditionFailed, "user has changed", errors.Wrap(err, action))
+	case user.ErrInvalidRole:
+		web.RespondWithCodedError(w, r, http.StatusBadRequest, "invalid role", errors.Wrap(err, action))
+	case user.ErrEmailInUse:
+		web.RespondWithCodedError(w, r, http.StatusConflict, "email already in use", errors.Wrap(err, action))
+	default:
+		// Something else went wrong
+		h.respondServerError(w, r, err, action)
+	}
+}
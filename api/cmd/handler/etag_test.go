@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+func TestPatchIfMatch(t *testing.T) {
+	h, users, _ := newTestHandler(t)
+	u := insertUser(t, users, "etag@example.com")
+
+	// The user routes sit behind RequireValidToken, so they are routed on their own
+	r := chi.NewRouter()
+	r.Get("/user/{ID}", h.GetUser)
+	r.Patch("/user/{ID}", h.Patch)
+	path := "/user/" + strconv.Itoa(u.ID)
+
+	patch := func(ifMatch, role string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, path, strings.NewReader(`{"role":"`+role+`"}`))
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("GET has no ETag")
+	}
+
+	w = patch(etag, "admin")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	next := w.Header().Get("ETag")
+	if next == "" || next == etag {
+		t.Fatalf("ETag after the write = %q, want a new one", next)
+	}
+
+	// The first ETag is stale now, so the write is refused and the current ETag is sent back
+	w = patch(etag, "user")
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("status with a stale ETag = %d, want %d: %s", w.Code, http.StatusPreconditionFailed, w.Body)
+	}
+	if got := w.Header().Get("ETag"); got != next {
+		t.Errorf("ETag with a stale ETag = %q, want %q", got, next)
+	}
+
+	// If-Match uses the strong comparison, so a weak tag never matches
+	w = patch("W/"+next, "user")
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("status with a weak ETag = %d, want %d: %s", w.Code, http.StatusPreconditionFailed, w.Body)
+	}
+
+	// Any of a list of tags, or *, matches
+	w = patch(`"nope", `+next, "user")
+	if w.Code != http.StatusOK {
+		t.Errorf("status with a list of ETags = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	w = patch("*", "admin")
+	if w.Code != http.StatusOK {
+		t.Errorf("status with * = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	// Without If-Match the write isn't conditional, unless the handler requires it
+	w = patch("", "user")
+	if w.Code != http.StatusOK {
+		t.Errorf("status without If-Match = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	h.requireIfMatch = true
+	w = patch("", "admin")
+	if w.Code != http.StatusPreconditionRequired {
+		t.Errorf("status without a required If-Match = %d, want %d: %s", w.Code, http.StatusPreconditionRequired, w.Body)
+	}
+}
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"expvar"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -14,39 +15,112 @@ import (
 	"github.com/go-redis/redis"
 	"github.com/jongschneider/youtube-project/api/internal/platform/auth"
 	"github.com/jongschneider/youtube-project/api/internal/platform/database"
+	"github.com/jongschneider/youtube-project/api/internal/platform/encryption"
+	"github.com/jongschneider/youtube-project/api/internal/platform/lockout"
+	"github.com/jongschneider/youtube-project/api/internal/platform/mail"
+	"github.com/jongschneider/youtube-project/api/internal/platform/password"
+	"github.com/jongschneider/youtube-project/api/internal/platform/ratelimit"
+	"github.com/jongschneider/youtube-project/api/internal/platform/user"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
 // Handler is an object that holds anything that might be necessary in various services.
 type Handler struct {
-	tz    *time.Location
-	db    *database.DB
-	cache *redis.Client
-	log   *logrus.Logger
-	auth  *auth.Service
+	tz      *time.Location
+	db      *database.DB
+	users   user.Store
+	cache   *redis.Client
+	log     *logrus.Logger
+	auth    *auth.Service
+	lockout *lockout.Guard
+	mailer  *mail.Mailer
+
+	// oauthRequests limits the requests to the OAuth endpoints per IP and per client
+	oauthRequests *ratelimit.Limiter
+
+	// resetRequests limits the password reset emails asked for per address
+	resetRequests mailLimiter
+
+	// resendRequests limits the verification emails asked for per address
+	resendRequests mailLimiter
+
+	// mailQueue holds the mail waiting for a worker to send it
+	mailQueue chan func()
+
+	passwords            *password.Policy
+	requireIfMatch       bool
+	passwordResetTTL     time.Duration
+	emailVerificationTTL time.Duration
 	http.Handler
 }
 
 // Config configures a new *Handler
 type Config struct {
-	DB    *database.DB
-	Cache *redis.Client
-	Auth  *auth.Service
-	Log   *logrus.Logger
-	Key   string
+	DB      *database.DB
+	Cache   *redis.Client
+	Auth    *auth.Service
+	Lockout *lockout.Guard
+	Mailer  *mail.Mailer
+	Log     *logrus.Logger
+	Key     string
+
+	// Users stores the users. It defaults to the users in DB.
+	Users user.Store
+
+	// Passwords decides which passwords users can choose. It defaults to a policy with the default rules.
+	Passwords *password.Policy
+
+	// RequireIfMatch should be true if writes to a user must be conditional on its ETag.
+	RequireIfMatch bool
+
+	// PasswordResetTTL is how long a password reset link is valid for.
+	PasswordResetTTL time.Duration
+
+	// EmailVerificationTTL is how long an email verification link is valid for.
+	EmailVerificationTTL time.Duration
 }
 
 // New returns a new Handler
 func New(cfg Config) *Handler {
 	h := Handler{
-		db:    cfg.DB,
-		cache: cfg.Cache,
-		auth:  cfg.Auth,
-		log:   cfg.Log,
+		db:      cfg.DB,
+		users:   cfg.Users,
+		cache:   cfg.Cache,
+		auth:    cfg.Auth,
+		lockout: cfg.Lockout,
+		mailer:  cfg.Mailer,
+		log:     cfg.Log,
+
+		oauthRequests:  ratelimit.New(cfg.Cache, "oauth", oauthLimit, oauthWindow),
+		resetRequests:  newMailLimiter(cfg.Cache, "password:reset", resetInterval, resetLimit, resetWindow),
+		resendRequests: newMailLimiter(cfg.Cache, "verify:resend", resendInterval, resendLimit, resendWindow),
+
+		passwords:            cfg.Passwords,
+		requireIfMatch:       cfg.RequireIfMatch,
+		passwordResetTTL:     cfg.PasswordResetTTL,
+		emailVerificationTTL: cfg.EmailVerificationTTL,
 	}
 
 	var err error
+
+	if h.users == nil {
+		h.users = user.NewSQLStore(cfg.DB)
+	}
+
+	// Without a policy passwords still have to meet the default rules
+	if h.passwords == nil {
+		pc := password.DefaultConfig()
+		pc.MaxBytes = encryption.MaxPasswordBytes()
+
+		h.passwords, err = password.New(pc)
+		if err != nil {
+			panic(errors.Wrap(err, "new password policy"))
+		}
+	}
+
+	h.startMailWorkers()
+
 	h.tz, err = time.LoadLocation("America/New_York")
 	if err != nil {
 		panic(errors.Wrap(err, "load location"))
@@ -56,9 +130,9 @@ func New(cfg Config) *Handler {
 
 	r.Use(cors.New(cors.Options{
 		AllowedOrigins: []string{"*"},
-		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders: []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders: []string{"Link"},
+		AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders: []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "If-Match"},
+		ExposedHeaders: []string{"Link", "ETag"},
 		MaxAge:         1000,
 	}).Handler)
 	r.Use(middleware.DefaultCompress)
@@ -71,6 +145,8 @@ func New(cfg Config) *Handler {
 	})
 
 	r.Get("/health", h.Health)
+	// Metrics describe the internals of the API, so only holders of the metrics scope can read them
+	r.With(h.auth.RequireValidToken, h.auth.RequireScope(user.ScopeMetricsRead)).Get("/metrics", expvar.Handler().ServeHTTP)
 
 	// Set up a static file server
 	workDir, err := os.Getwd()
@@ -80,11 +156,26 @@ func New(cfg Config) *Handler {
 	filesDir := filepath.Join(workDir, "static")
 	h.FileServer(r, "/static", http.Dir(filesDir))
 
+	r.Get("/.well-known/jwks.json", h.auth.JWKSHandler)
 	r.Get("/token", h.auth.IssueTokenHandler)
+	r.Post("/token/refresh", h.auth.RefreshTokenHandler)
+	r.Post("/password/forgot", h.ForgotPassword)
+	r.Post("/password/reset", h.ResetPassword)
+	r.Get("/email/verify", h.VerifyEmail)
+	r.Post("/email/verify/resend", h.ResendVerification)
+
+	r.Post("/oauth/token", h.OAuthToken)
+	r.Post("/oauth/introspect", h.OAuthIntrospect)
+	r.Post("/oauth/revoke", h.OAuthRevoke)
 
 	r.Route("/auth", func(r chi.Router) {
 		r.Use(h.auth.RequireValidToken)
 		r.Post("/login", h.Login)
+		r.Post("/login/mfa", h.LoginMFA)
+		r.Post("/logout", h.auth.LogoutHandler)
+		r.Post("/password", h.ChangePassword)
+		r.Mount("/mfa", h.mfaRouter())
+		r.Mount("/sessions", h.sessionRouter())
 		r.Mount("/user", h.userRouter())
 	})
 
@@ -95,11 +186,37 @@ func New(cfg Config) *Handler {
 
 // userRouter is an example of how to create a subrouter used for versioning
 func (h *Handler) userRouter() http.Handler {
+	read := h.auth.RequireScope(user.ScopeUsersRead)
+	write := h.auth.RequireScope(user.ScopeUsersWrite)
+
 	r := chi.NewRouter()
+	// Anyone with a token can sign up. New users always get the user role, so only changing a user needs write.
 	r.Post("/", h.Create)
-	r.Get("/", h.GetAllUsers)
-	r.Get("/{ID}", h.GetUser)
-	r.Delete("/{ID}", h.Delete)
-	r.Put("/{ID}", h.Update)
+	r.With(read).Get("/", h.GetAllUsers)
+	r.With(read).Get("/{ID}", h.GetUser)
+	r.With(write).Delete("/{ID}", h.Delete)
+	r.With(write).Put("/{ID}", h.Update)
+	r.With(write).Patch("/{ID}", h.Patch)
+	r.With(write).Post("/{ID}/unlock", h.Unlock)
+	r.With(write).Post("/{ID}/restore", h.Restore)
+	return r
+}
+
+// mfaRouter handles MFA enrollment for the calling user
+func (h *Handler) mfaRouter() http.Handler {
+	r := chi.NewRouter()
+	r.Post("/totp", h.EnrollTOTP)
+	r.Get("/totp/qr.png", h.TOTPQRCode)
+	r.Post("/totp/confirm", h.ConfirmTOTP)
+	r.Delete("/totp", h.DisableTOTP)
+	return r
+}
+
+// sessionRouter lets the calling user see and end their sessions
+func (h *Handler) sessionRouter() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/", h.Sessions)
+	r.Delete("/", h.RevokeOtherSessions)
+	r.Delete("/{sessionID}", h.RevokeSession)
 	return r
 }
@@ -0,0 +1,594 @@
+package handler
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"database/sql"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-chi/chi"
+	"github.com/go-redis/redis"
+	"github.com/jongschneider/youtube-project/api/internal/platform/auth"
+	"github.com/jongschneider/youtube-project/api/internal/platform/database"
+	"github.com/jongschneider/youtube-project/api/internal/platform/encryption"
+	"github.com/jongschneider/youtube-project/api/internal/platform/lockout"
+	"github.com/jongschneider/youtube-project/api/internal/platform/mail"
+	"github.com/jongschneider/youtube-project/api/internal/platform/oauth"
+	"github.com/jongschneider/youtube-project/api/internal/platform/user"
+	"github.com/sirupsen/logrus"
+)
+
+func TestMain(m *testing.M) {
+	// Cheap hashes keep the tests fast
+	err := encryption.Configure(encryption.Config{Algorithm: encryption.Bcrypt, BcryptCost: 4})
+	if err != nil {
+		panic(err)
+	}
+
+	os.Exit(m.Run())
+}
+
+// sentMail collects the mail sent by a test handler.
+type sentMail chan mail.Message
+
+func (s sentMail) Send(m mail.Message) error {
+	s <- m
+	return nil
+}
+
+// newTestHandler returns a Handler backed by a MemoryStore, along with the store and the mail it sends.
+// There is no db, so only what doesn't need one can be tested.
+func newTestHandler(t *testing.T) (*Handler, *user.MemoryStore, sentMail) {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+
+	log := logrus.New()
+	log.Out = ioutil.Discard
+
+	users := user.NewMemoryStore()
+	sent := make(sentMail, 10)
+
+	h := New(Config{
+		Users: users,
+		Cache: cache,
+		Auth: auth.New(auth.Config{
+			Algorithm:  "EdDSA",
+			PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+			Cache:      cache,
+			Enforce:    true,
+			AbortRequest: func(w http.ResponseWriter, r *http.Request, err error, statusCode int) {
+				w.WriteHeader(statusCode)
+			},
+		}),
+		Lockout: lockout.New(cache, lockout.Config{}),
+		Mailer:  mail.NewWithSender(mail.Config{BaseURL: "http://client.test", APIBaseURL: "http://api.test"}, sent),
+		Log:     log,
+
+		PasswordResetTTL:     time.Hour,
+		EmailVerificationTTL: time.Hour,
+	})
+
+	return h, users, sent
+}
+
+// insertUser adds a user to the store and returns it.
+func insertUser(t *testing.T, users *user.MemoryStore, email string) user.User {
+	t.Helper()
+
+	id, err := users.Insert(context.Background(), user.User{Email: email, Password: "Correct-Horse-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := users.GetByID(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return u
+}
+
+func TestHealthWithoutDB(t *testing.T) {
+	h, _, _ := newTestHandler(t)
+
+	w := httptest.NewRecorder()
+	h.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	if strings.Contains(w.Body.String(), `"db"`) {
+		t.Errorf("body reports db stats without a db: %s", w.Body)
+	}
+}
+
+func TestMetricsRequiresToken(t *testing.T) {
+	h, _, _ := newTestHandler(t)
+
+	w := httptest.NewRecorder()
+	h.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestVerifyEmail(t *testing.T) {
+	h, users, _ := newTestHandler(t)
+	u := insertUser(t, users, "verify@example.com")
+
+	verify := func(email string) int {
+		token, err := h.auth.NewEmailVerificationToken(strconv.Itoa(u.ID), email, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		h.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/email/verify?token="+url.QueryEscape(token), nil))
+		return w.Code
+	}
+
+	if code := verify(u.Email); code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d", code, http.StatusNoContent)
+	}
+
+	got, err := users.GetByID(context.Background(), u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.EmailVerified() {
+		t.Error("email wasn't verified")
+	}
+
+	// A link sent to an old email can't verify the new one
+	if code := verify("old@example.com"); code != http.StatusBadRequest {
+		t.Errorf("status for old email = %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	h, users, _ := newTestHandler(t)
+	u := insertUser(t, users, "restore@example.com")
+
+	// Restore sits behind RequireValidToken, which needs the cache, so it is routed on its own
+	r := chi.NewRouter()
+	r.Post("/user/{ID}/restore", h.Restore)
+
+	restore := func() int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/user/"+strconv.Itoa(u.ID)+"/restore", nil))
+		return w.Code
+	}
+
+	// Only deleted users can be restored
+	if code := restore(); code != http.StatusBadRequest {
+		t.Errorf("status before delete = %d, want %d", code, http.StatusBadRequest)
+	}
+
+	err := users.Delete(context.Background(), u.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if code := restore(); code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d", code, http.StatusNoContent)
+	}
+
+	_, err = users.GetByID(context.Background(), u.ID)
+	if err == sql.ErrNoRows {
+		t.Error("user is still deleted")
+	} else if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRestoreEmailInUse(t *testing.T) {
+	h, users, _ := newTestHandler(t)
+	u := insertUser(t, users, "taken@example.com")
+
+	err := users.Delete(context.Background(), u.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Someone signs up with the email while the user is deleted
+	insertUser(t, users, "taken@example.com")
+
+	r := chi.NewRouter()
+	r.Post("/user/{ID}/restore", h.Restore)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/user/"+strconv.Itoa(u.ID)+"/restore", nil))
+
+	if w.Code != http.StatusConflict {
+		t.Errorf("status = %d, want %d: %s", w.Code, http.StatusConflict, w.Body)
+	}
+}
+
+func TestCreateEmailInUse(t *testing.T) {
+	h, users, _ := newTestHandler(t)
+	insertUser(t, users, "taken@example.com")
+
+	// Create sits behind RequireValidToken, so it is routed on its own
+	r := chi.NewRouter()
+	r.Post("/user", h.Create)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/user", strings.NewReader(`{"email":"taken@example.com","password":"Another-Horse-2"}`)))
+
+	if w.Code != http.StatusConflict {
+		t.Errorf("status = %d, want %d: %s", w.Code, http.StatusConflict, w.Body)
+	}
+}
+
+func TestForgotPassword(t *testing.T) {
+	h, users, sent := newTestHandler(t)
+	u := insertUser(t, users, "forgot@example.com")
+
+	forgot := func(email string) int {
+		req := httptest.NewRequest(http.MethodPost, "/password/forgot", strings.NewReader(url.Values{"email": {email}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		w := httptest.NewRecorder()
+		h.Handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Unknown emails get the same response, and no mail
+	if code := forgot("nobody@example.com"); code != http.StatusAccepted {
+		t.Errorf("status for unknown email = %d, want %d", code, http.StatusAccepted)
+	}
+
+	if code := forgot(u.Email); code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d", code, http.StatusAccepted)
+	}
+
+	var m mail.Message
+	select {
+	case m = <-sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reset email was sent")
+	}
+
+	if m.To != u.Email {
+		t.Fatalf("reset email sent to %s, want %s", m.To, u.Email)
+	}
+
+	match := regexp.MustCompile(`http://client\.test/reset-password\?token=(\S+)`).FindStringSubmatch(m.Body)
+	if match == nil {
+		t.Fatalf("no reset link in email: %s", m.Body)
+	}
+
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := users.GetByResetToken(context.Background(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != u.ID {
+		t.Errorf("reset token is for user %d, want %d", got.ID, u.ID)
+	}
+
+	// Differently written forms of the address share the limit, whether or not it belongs to a user
+	if code := forgot(" FORGOT@example.com"); code != http.StatusTooManyRequests {
+		t.Errorf("status for a quick second request = %d, want %d", code, http.StatusTooManyRequests)
+	}
+	if code := forgot("nobody@example.com"); code != http.StatusTooManyRequests {
+		t.Errorf("status for a quick second request for an unknown email = %d, want %d", code, http.StatusTooManyRequests)
+	}
+}
+
+func TestQueueMailFull(t *testing.T) {
+	// Nothing takes mail off an unbuffered queue without workers
+	h := &Handler{mailQueue: make(chan func())}
+
+	if h.queueMail(func() {}) {
+		t.Error("mail was queued on a full queue")
+	}
+}
+
+func TestResendVerification(t *testing.T) {
+	h, users, sent := newTestHandler(t)
+	u := insertUser(t, users, "resend@example.com")
+
+	resend := func(email string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/email/verify/resend", strings.NewReader(url.Values{"email": {email}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		w := httptest.NewRecorder()
+		h.Handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// Unknown emails get the same response, and no mail
+	if w := resend("nobody@example.com"); w.Code != http.StatusAccepted {
+		t.Errorf("status for unknown email = %d, want %d: %s", w.Code, http.StatusAccepted, w.Body)
+	}
+
+	if w := resend(u.Email); w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusAccepted, w.Body)
+	}
+
+	select {
+	case m := <-sent:
+		if m.To != u.Email {
+			t.Errorf("verification email sent to %s, want %s", m.To, u.Email)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no verification email was sent")
+	}
+
+	// Differently written forms of the address share the limit
+	w := resend(" RESEND@example.com")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status for a quick second request = %d, want %d: %s", w.Code, http.StatusTooManyRequests, w.Body)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("no Retry-After header")
+	}
+}
+
+func TestResetPasswordDeletedUser(t *testing.T) {
+	h, users, _ := newTestHandler(t)
+	u := insertUser(t, users, "deleted@example.com")
+
+	token, err := users.CreatePasswordReset(context.Background(), u.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = users.Delete(context.Background(), u.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/password/reset", strings.NewReader(url.Values{
+		"token":    {token},
+		"password": {"Another-Horse-2"},
+	}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	w := httptest.NewRecorder()
+	h.Handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body)
+	}
+
+	// The store doesn't change a deleted user's password either
+	_, err = users.ResetPassword(context.Background(), token, "Another-Horse-2")
+	if err != sql.ErrNoRows {
+		t.Errorf("err = %v, want %v", err, sql.ErrNoRows)
+	}
+}
+
+func TestResetPasswordDefaultPolicy(t *testing.T) {
+	// The test handler isn't given a policy, so it uses the default one
+	h, users, _ := newTestHandler(t)
+	u := insertUser(t, users, "policy@example.com")
+
+	token, err := users.CreatePasswordReset(context.Background(), u.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/password/reset", strings.NewReader(url.Values{
+		"token":    {token},
+		"password": {"short"},
+	}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	w := httptest.NewRecorder()
+	h.Handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status = %d, want %d: %s", w.Code, http.StatusUnprocessableEntity, w.Body)
+	}
+}
+
+func TestResetPasswordUsedToken(t *testing.T) {
+	h, users, _ := newTestHandler(t)
+	u := insertUser(t, users, "reset@example.com")
+
+	token, err := users.CreatePasswordReset(context.Background(), u.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = users.ResetPassword(context.Background(), token, "Another-Horse-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/password/reset", strings.NewReader(url.Values{
+		"token":    {token},
+		"password": {"Third-Horse-3"},
+	}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	w := httptest.NewRecorder()
+	h.Handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body)
+	}
+}
+
+func TestOAuthTokenRateLimited(t *testing.T) {
+	h, _, _ := newTestHandler(t)
+
+	token := func() *httptest.ResponseRecorder {
+		// Requests without credentials are rejected before the db is needed
+		req := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(url.Values{"grant_type": {"client_credentials"}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		w := httptest.NewRecorder()
+		h.Handler.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < oauthLimit; i++ {
+		if w := token(); w.Code != http.StatusUnauthorized {
+			t.Fatalf("request %d: status = %d, want %d: %s", i+1, w.Code, http.StatusUnauthorized, w.Body)
+		}
+	}
+
+	w := token()
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusTooManyRequests, w.Body)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("no Retry-After header")
+	}
+}
+
+// addClient gives the handler a db holding an OAuth client with the ID, and returns the client's secret.
+func addClient(t *testing.T, h *Handler, id string) string {
+	t.Helper()
+
+	if h.db == nil {
+		db, err := database.Open(database.SQLite, ":memory:", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+
+		// Every connection to :memory: is a new database
+		db.SetMaxOpenConns(1)
+
+		_, err = db.DB.Exec(`CREATE TABLE oauth_clients (id VARCHAR(100) PRIMARY KEY, secret VARCHAR(100) NOT NULL, scopes VARCHAR(255) NOT NULL)`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		h.db = db
+	}
+
+	secret, hash, err := oauth.NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = h.db.DB.Exec(`INSERT INTO oauth_clients (id, secret, scopes) VALUES (?, ?, ?)`, id, hash, "users:read")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return secret
+}
+
+// postClientForm posts the form to the path as the client.
+func postClientForm(h *Handler, path, id, secret string, v url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(v.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(id, secret)
+
+	w := httptest.NewRecorder()
+	h.Handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestOAuthRevoke(t *testing.T) {
+	h, _, _ := newTestHandler(t)
+	secret := addClient(t, h, "reporting")
+	otherSecret := addClient(t, h, "billing")
+
+	token, err := h.auth.NewSignedToken(auth.NewClientClaims("reporting", []string{"users:read"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	active := func() bool {
+		w := postClientForm(h, "/oauth/introspect", "reporting", secret, url.Values{"token": {token}})
+		if w.Code != http.StatusOK {
+			t.Fatalf("introspect status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+		}
+
+		var res introspectResponse
+		err := json.NewDecoder(w.Body).Decode(&res)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return res.Active
+	}
+
+	if !active() {
+		t.Fatal("new token isn't active")
+	}
+
+	// Clients can't revoke tokens issued to other clients
+	w := postClientForm(h, "/oauth/revoke", "billing", otherSecret, url.Values{"token": {token}})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status revoking another client's token = %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body)
+	}
+	if !active() {
+		t.Fatal("token was revoked by another client")
+	}
+
+	w = postClientForm(h, "/oauth/revoke", "reporting", secret, url.Values{"token": {token}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	if active() {
+		t.Error("revoked token is still active")
+	}
+
+	// Revoking again, or revoking an unknown token, isn't an error (RFC 7009 section 2.2)
+	w = postClientForm(h, "/oauth/revoke", "reporting", secret, url.Values{"token": {"unknown"}})
+	if w.Code != http.StatusOK {
+		t.Errorf("status for unknown token = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+}
+
+func TestOAuthRevokeRateLimited(t *testing.T) {
+	h, _, _ := newTestHandler(t)
+	secret := addClient(t, h, "reporting")
+
+	// Every request counts, whether or not the client authenticates
+	for i := 0; i < oauthLimit; i++ {
+		w := postClientForm(h, "/oauth/revoke", "reporting", "wrong", url.Values{"token": {"unknown"}})
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("request %d: status = %d, want %d: %s", i+1, w.Code, http.StatusUnauthorized, w.Body)
+		}
+	}
+
+	w := postClientForm(h, "/oauth/revoke", "reporting", secret, url.Values{"token": {"unknown"}})
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusTooManyRequests, w.Body)
+	}
+
+	w = postClientForm(h, "/oauth/introspect", "reporting", secret, url.Values{"token": {"unknown"}})
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("introspect status = %d, want %d: %s", w.Code, http.StatusTooManyRequests, w.Body)
+	}
+}
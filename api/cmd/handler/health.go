@@ -3,20 +3,33 @@ package handler
 import (
 	"net/http"
 
+	"github.com/jongschneider/youtube-project/api/internal/platform/database"
 	"github.com/jongschneider/youtube-project/api/internal/platform/web"
 )
 
-// Health is the health check for the application
+type healthResponse struct {
+	web.Response
+	DB *database.PoolStats `json:"db,omitempty"`
+}
+
+// Health is the health check for the application. It also reports on the db's connection pool,
+// unless the handler was set up without a db.
 func (h *Handler) Health(w http.ResponseWriter, r *http.Request) {
-	if err := h.db.PingContext(r.Context()); err != nil {
-		web.RespondWithCodedError(w, r, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError), err)
-		return
+	response := healthResponse{
+		Response: web.Response{
+			Message: "Healthy",
+		},
 	}
 
-	response := web.Response{
-		Message: "Healthy",
+	if h.db != nil {
+		if err := h.db.PingContext(r.Context()); err != nil {
+			web.RespondWithCodedError(w, r, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError), err)
+			return
+		}
+
+		stats := h.db.PoolStats()
+		response.DB = &stats
 	}
 
 	web.Respond(w, r, response, http.StatusOK)
-	return
 }
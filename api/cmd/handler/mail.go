@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/jongschneider/youtube-project/api/internal/platform/ratelimit"
+)
+
+const (
+	// mailWorkers is how many emails asked for by requests are sent at once.
+	mailWorkers = 4
+
+	// mailQueueSize is how many emails can wait for a worker. Emails asked for while the queue is full are dropped.
+	mailQueueSize = 100
+)
+
+// mailLimiter limits how often mail can be asked for to an address: once per interval, and limit times per window.
+// The limits apply whether or not the address belongs to a user, so they don't give away which addresses do.
+type mailLimiter struct {
+	recent *ratelimit.Limiter
+	count  *ratelimit.Limiter
+}
+
+func newMailLimiter(c *redis.Client, name string, interval time.Duration, limit int, window time.Duration) mailLimiter {
+	return mailLimiter{
+		recent: ratelimit.New(c, fmt.Sprintf("%s:recent", name), 1, interval),
+		count:  ratelimit.New(c, fmt.Sprintf("%s:count", name), limit, window),
+	}
+}
+
+// Allow records a request for mail to the address.
+// It returns ratelimit.ErrLimited, along with how long to wait, if the address has asked too often.
+func (l mailLimiter) Allow(email string) (time.Duration, error) {
+	// Differently written forms of the same address share a limit
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	wait, err := l.recent.Allow(email)
+	if err != nil {
+		return wait, err
+	}
+
+	return l.count.Allow(email)
+}
+
+// queueMail runs send on one of the mail workers, so requests don't wait for mail to be sent
+// and a burst of requests can't start an unbounded number of sends. It returns false if the queue is full.
+func (h *Handler) queueMail(send func()) bool {
+	select {
+	case h.mailQueue <- send:
+		return true
+	default:
+		return false
+	}
+}
+
+// startMailWorkers starts the workers that send the mail queued by queueMail.
+func (h *Handler) startMailWorkers() {
+	h.mailQueue = make(chan func(), mailQueueSize)
+
+	for i := 0; i < mailWorkers; i++ {
+		go func() {
+			for send := range h.mailQueue {
+				send()
+			}
+		}()
+	}
+}
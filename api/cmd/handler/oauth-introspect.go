@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/jongschneider/youtube-project/api/internal/platform/auth"
+	"github.com/jongschneider/youtube-project/api/internal/platform/oauth"
+	"github.com/jongschneider/youtube-project/api/internal/platform/web"
+	"github.com/pkg/errors"
+)
+
+type introspectResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	Audience  string `json:"aud,omitempty"`
+	Issuer    string `json:"iss,omitempty"`
+	ID        string `json:"jti,omitempty"`
+}
+
+// OAuthIntrospect tells an authenticated client whether a token is active and what it was issued for (RFC 7662)
+func (h *Handler) OAuthIntrospect(w http.ResponseWriter, r *http.Request) {
+	client, ok := h.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+
+	token := r.PostFormValue("token")
+	if token == "" {
+		oauth.RespondWithError(w, r, http.StatusBadRequest, oauth.ErrorInvalidRequest, "missing token")
+		return
+	}
+
+	// The hint only decides which kind of token is checked first.
+	checks := []func(string) (introspectResponse, error){h.introspectAccessToken, h.introspectRefreshToken}
+	if r.PostFormValue("token_type_hint") == "refresh_token" {
+		checks = []func(string) (introspectResponse, error){h.introspectRefreshToken, h.introspectAccessToken}
+	}
+
+	response := introspectResponse{}
+	for _, check := range checks {
+		res, err := check(token)
+		if err != nil {
+			h.log.WithError(err).Info()
+			oauth.RespondWithError(w, r, http.StatusInternalServerError, oauth.ErrorServerError, "")
+			return
+		}
+
+		if res.Active {
+			response = res
+			break
+		}
+	}
+
+	h.log.WithField("client_id", client.ID).WithField("active", response.Active).Info("token introspected")
+
+	oauth.NoStore(w)
+	web.Respond(w, r, response, http.StatusOK)
+}
+
+// introspectAccessToken checks the token's signature, claims and record in the cache.
+func (h *Handler) introspectAccessToken(token string) (introspectResponse, error) {
+	claims, err := h.auth.ValidateToken(token)
+	switch errors.Cause(err) {
+	case nil:
+	case auth.ErrInvalidToken, auth.ErrTokenNotFound, auth.ErrTokenRevoked:
+		return introspectResponse{}, nil
+	default:
+		return introspectResponse{}, errors.Wrap(err, "validate token")
+	}
+
+	res := claimsResponse(claims)
+	res.TokenType = "access_token"
+	return res, nil
+}
+
+// introspectRefreshToken checks the token is a refresh token that hasn't been used.
+func (h *Handler) introspectRefreshToken(token string) (introspectResponse, error) {
+	claims, err := h.auth.RefreshTokenClaims(token)
+	switch errors.Cause(err) {
+	case nil:
+	case auth.ErrInvalidRefreshToken:
+		return introspectResponse{}, nil
+	default:
+		return introspectResponse{}, errors.Wrap(err, "refresh token claims")
+	}
+
+	res := claimsResponse(claims)
+	res.TokenType = "refresh_token"
+	return res, nil
+}
+
+func claimsResponse(c auth.Claims) introspectResponse {
+	return introspectResponse{
+		Active:    true,
+		Scope:     c.Scope,
+		ClientID:  c.ClientID,
+		Username:  c.Email,
+		ExpiresAt: c.ExpiresAt,
+		IssuedAt:  c.IssuedAt,
+		Subject:   c.Subject,
+		Audience:  c.Audience,
+		Issuer:    c.Issuer,
+		ID:        c.Id,
+	}
+}
+
+// authenticateClient parses the form and authenticates the client making the request, within the OAuth rate limits.
+// If the client can't be authenticated, an RFC 6749 error is sent and false is returned.
+func (h *Handler) authenticateClient(w http.ResponseWriter, r *http.Request) (oauth.Client, bool) {
+	err := r.ParseForm()
+	if err != nil {
+		h.log.WithError(err).Info()
+		oauth.RespondWithError(w, r, http.StatusBadRequest, oauth.ErrorInvalidRequest, "malformed request")
+		return oauth.Client{}, false
+	}
+
+	if !h.allowOAuthRequest(w, r) {
+		return oauth.Client{}, false
+	}
+
+	client, err := oauth.Authenticate(h.db, r)
+	if err != nil {
+		if errors.Cause(err) == oauth.ErrInvalidClient {
+			h.log.WithError(err).Info()
+			oauth.RespondWithError(w, r, http.StatusUnauthorized, oauth.ErrorInvalidClient, "client authentication failed")
+			return oauth.Client{}, false
+		}
+
+		// Something else went wrong
+		h.log.WithError(err).Info()
+		oauth.RespondWithError(w, r, http.StatusInternalServerError, oauth.ErrorServerError, "")
+		return oauth.Client{}, false
+	}
+
+	return client, true
+}
@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/jongschneider/youtube-project/api/internal/platform/auth"
+	"github.com/jongschneider/youtube-project/api/internal/platform/oauth"
+	"github.com/jongschneider/youtube-project/api/internal/platform/web"
+	"github.com/pkg/errors"
+)
+
+// OAuthRevoke lets an authenticated client revoke an access or refresh token (RFC 7009)
+func (h *Handler) OAuthRevoke(w http.ResponseWriter, r *http.Request) {
+	client, ok := h.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+
+	token := r.PostFormValue("token")
+	if token == "" {
+		oauth.RespondWithError(w, r, http.StatusBadRequest, oauth.ErrorInvalidRequest, "missing token")
+		return
+	}
+
+	// A client may only revoke tokens that were issued to it (RFC 7009 section 2.1), so tokens issued to users
+	// or to other clients are refused. Access tokens are JWTs; anything else is treated as a refresh token.
+	// The signature isn't checked because revoking a forged token is harmless.
+	claims := &auth.Claims{}
+	_, _, err := new(jwt.Parser).ParseUnverified(token, claims)
+	if err == nil {
+		if claims.ClientID == "" || claims.ClientID != client.ID {
+			oauth.RespondWithError(w, r, http.StatusBadRequest, oauth.ErrorUnauthorizedClient, auth.ErrWrongClient.Error())
+			return
+		}
+
+		err = h.auth.RevokeToken(token)
+	} else {
+		err = h.auth.RevokeRefreshToken(token, client.ID)
+	}
+
+	switch errors.Cause(err) {
+	case nil:
+	case auth.ErrWrongClient:
+		oauth.RespondWithError(w, r, http.StatusBadRequest, oauth.ErrorUnauthorizedClient, err.Error())
+		return
+	default:
+		h.log.WithError(err).Info()
+		oauth.RespondWithError(w, r, http.StatusServiceUnavailable, oauth.ErrorServerError, "")
+		return
+	}
+
+	h.log.WithField("client_id", client.ID).Info("token revoked")
+
+	web.Respond(w, r, struct{}{}, http.StatusOK)
+}
@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jongschneider/youtube-project/api/internal/platform/auth"
+	"github.com/jongschneider/youtube-project/api/internal/platform/oauth"
+	"github.com/jongschneider/youtube-project/api/internal/platform/ratelimit"
+	"github.com/jongschneider/youtube-project/api/internal/platform/web"
+	"github.com/pkg/errors"
+)
+
+const (
+	// oauthLimit is how many requests an IP, and separately a client, can make to the OAuth endpoints within oauthWindow.
+	oauthLimit  = 30
+	oauthWindow = time.Minute
+)
+
+type oauthTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	Scope       string `json:"scope,omitempty"`
+}
+
+// OAuthToken issues access tokens to registered clients using the OAuth 2.0 client credentials grant (RFC 6749 section 4.4)
+func (h *Handler) OAuthToken(w http.ResponseWriter, r *http.Request) {
+	// Parse the content of the form
+	err := r.ParseForm()
+	if err != nil {
+		h.log.WithError(err).Info()
+		oauth.RespondWithError(w, r, http.StatusBadRequest, oauth.ErrorInvalidRequest, "malformed request")
+		return
+	}
+
+	grantType := r.PostFormValue("grant_type")
+	if grantType == "" {
+		oauth.RespondWithError(w, r, http.StatusBadRequest, oauth.ErrorInvalidRequest, "missing grant_type")
+		return
+	}
+
+	if grantType != "client_credentials" {
+		oauth.RespondWithError(w, r, http.StatusBadRequest, oauth.ErrorUnsupportedGrantType, "")
+		return
+	}
+
+	if !h.allowOAuthRequest(w, r) {
+		return
+	}
+
+	// Go out to the db and make sure the client's credentials are valid
+	client, err := oauth.Authenticate(h.db, r)
+	if err != nil {
+		if errors.Cause(err) == oauth.ErrInvalidClient {
+			h.log.WithError(err).Info()
+			oauth.RespondWithError(w, r, http.StatusUnauthorized, oauth.ErrorInvalidClient, "client authentication failed")
+			return
+		}
+
+		// Something else went wrong
+		h.log.WithError(err).Info()
+		oauth.RespondWithError(w, r, http.StatusInternalServerError, oauth.ErrorServerError, "")
+		return
+	}
+
+	scopes, ok := client.GrantScopes(r.PostFormValue("scope"))
+	if !ok {
+		oauth.RespondWithError(w, r, http.StatusBadRequest, oauth.ErrorInvalidScope, "requested scope is not allowed for this client")
+		return
+	}
+
+	token, err := h.auth.NewSignedToken(auth.NewClientClaims(client.ID, scopes))
+	if err != nil {
+		h.log.WithError(err).Info()
+		oauth.RespondWithError(w, r, http.StatusInternalServerError, oauth.ErrorServerError, "could not issue token")
+		return
+	}
+
+	oauth.NoStore(w)
+	web.Respond(w, r, oauthTokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(h.auth.TTL() / time.Second),
+		Scope:       strings.Join(scopes, " "),
+	}, http.StatusOK)
+}
+
+// allowOAuthRequest counts a request to an OAuth endpoint against the limits of its IP and of the client it claims to be.
+// If either is used up it responds with an error and returns false.
+func (h *Handler) allowOAuthRequest(w http.ResponseWriter, r *http.Request) bool {
+	keys := []string{"ip:" + clientIP(r)}
+	if id, _ := oauth.Credentials(r); id != "" {
+		keys = append(keys, "client:"+id)
+	}
+
+	for _, key := range keys {
+		wait, err := h.oauthRequests.Allow(key)
+		if errors.Cause(err) == ratelimit.ErrLimited {
+			w.Header().Set("Retry-After", retryAfter(wait))
+			oauth.RespondWithError(w, r, http.StatusTooManyRequests, oauth.ErrorInvalidRequest, "too many requests")
+			return false
+		}
+		if err != nil {
+			h.log.WithError(err).Info()
+			oauth.RespondWithError(w, r, http.StatusInternalServerError, oauth.ErrorServerError, "")
+			return false
+		}
+	}
+
+	return true
+}
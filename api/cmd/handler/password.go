@@ -0,0 +1,254 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/jongschneider/youtube-project/api/internal/platform/auth"
+	"github.com/jongschneider/youtube-project/api/internal/platform/encryption"
+	"github.com/jongschneider/youtube-project/api/internal/platform/password"
+	"github.com/jongschneider/youtube-project/api/internal/platform/ratelimit"
+	"github.com/jongschneider/youtube-project/api/internal/platform/user"
+	"github.com/jongschneider/youtube-project/api/internal/platform/web"
+	"github.com/pkg/errors"
+)
+
+const (
+	// resetInterval is how long someone has to wait between asking for password reset emails to the same address.
+	resetInterval = time.Minute
+
+	// resetLimit is how many password reset emails can be asked for per address within resetWindow.
+	resetLimit  = 5
+	resetWindow = 24 * time.Hour
+)
+
+type forgotPasswordResponse struct {
+	web.Response
+}
+
+// ForgotPassword emails a password reset link to the provided email.
+// It responds the same way whether or not the email belongs to a user.
+func (h *Handler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	// Parse the content of the form
+	err := r.ParseForm()
+	if err != nil {
+		h.log.WithError(err)
+		web.RespondWithCodedError(w, r, http.StatusBadRequest, "bad request", err)
+		return
+	}
+
+	email := r.FormValue("email")
+	if email == "" {
+		web.RespondWithCodedError(w, r, http.StatusBadRequest, "missing email", errors.New("forgot password: missing email"))
+		return
+	}
+
+	wait, err := h.resetRequests.Allow(email)
+	if err != nil {
+		h.log.WithError(err).Info()
+		if errors.Cause(err) == ratelimit.ErrLimited {
+			w.Header().Set("Retry-After", retryAfter(wait))
+			web.RespondWithCodedError(w, r, http.StatusTooManyRequests, "too many attempts", errors.Wrap(err, "forgot password"))
+			return
+		}
+
+		h.respondServerError(w, r, err, "forgot password")
+		return
+	}
+
+	// Look up the user and send the email in the background, so the response
+	// takes the same time and is the same whether or not the email belongs to a user
+	if !h.queueMail(func() { h.sendPasswordReset(email) }) {
+		h.log.WithField("action", "forgot password").Warn("mail queue is full, dropping password reset")
+	}
+
+	web.Respond(w, r, forgotPasswordResponse{
+		Response: web.Response{
+			Message: "if the email belongs to an account, a reset link has been sent",
+		},
+	}, http.StatusAccepted)
+}
+
+// sendPasswordReset emails a password reset link to the user with the email, if there is one.
+// It runs after the response has been sent, so failures are only logged.
+func (h *Handler) sendPasswordReset(email string) {
+	ctx := context.Background()
+	log := h.log.WithField("action", "forgot password")
+
+	// Go out to the db and try to get the user associated with the provided email
+	u, err := h.users.GetByEmail(ctx, email)
+	if err != nil {
+		// The email was not in the db, so there is nobody to send a link to
+		if err != sql.ErrNoRows {
+			log.WithError(err).Info()
+		}
+		return
+	}
+
+	token, err := h.users.CreatePasswordReset(ctx, u.ID, h.passwordResetTTL)
+	if err != nil {
+		log.WithError(err).Info()
+		return
+	}
+
+	link := h.mailer.Link("/reset-password", url.Values{"token": {token}})
+	body := fmt.Sprintf("Someone asked to reset the password for your account.\n\nTo choose a new password, follow this link within %s:\n\n%s\n\nIf this wasn't you, you can ignore this email.", h.passwordResetTTL, link)
+
+	err = h.mailer.Send(u.Email, "Reset your password", body)
+	if err != nil {
+		log.WithError(errors.Wrap(err, "send password reset")).Info()
+	}
+}
+
+// ResetPassword sets a new password using a token from ForgotPassword and signs the user out everywhere
+func (h *Handler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	// Parse the content of the form
+	err := r.ParseForm()
+	if err != nil {
+		h.log.WithError(err)
+		web.RespondWithCodedError(w, r, http.StatusBadRequest, "bad request", err)
+		return
+	}
+
+	token := r.FormValue("token")
+	pass := r.FormValue("password")
+	if token == "" || pass == "" {
+		web.RespondWithCodedError(w, r, http.StatusBadRequest, "missing token or password", errors.New("reset password: missing token or password"))
+		return
+	}
+
+	// The new password has to meet the policy for the user it is being set for
+	u, err := h.users.GetByResetToken(r.Context(), token)
+	if err != nil {
+		h.log.WithError(err).Info()
+		// Deleted users can't reset their password
+		switch errors.Cause(err) {
+		case user.ErrInvalidResetToken, sql.ErrNoRows:
+			web.RespondWithCodedError(w, r, http.StatusBadRequest, "invalid or expired token", errors.Wrap(err, "reset password"))
+			return
+		}
+
+		// Something else went wrong
+		h.respondServerError(w, r, err, "reset password")
+		return
+	}
+
+	if !h.validPassword(w, r, pass, u.Email) {
+		return
+	}
+
+	u, err = h.users.ResetPassword(r.Context(), token, pass)
+	if err != nil {
+		h.log.WithError(err).Info()
+		switch errors.Cause(err) {
+		case user.ErrInvalidResetToken, sql.ErrNoRows:
+			web.RespondWithCodedError(w, r, http.StatusBadRequest, "invalid or expired token", errors.Wrap(err, "reset password"))
+			return
+		}
+
+		// Something else went wrong
+		h.respondServerError(w, r, err, "reset password")
+		return
+	}
+
+	// Every session started with the old password has to go
+	err = h.auth.RevokeAllForSubject(strconv.Itoa(u.ID))
+	if err != nil {
+		h.log.WithError(err).Info()
+		h.respondServerError(w, r, err, "reset password")
+		return
+	}
+
+	// The user proved they own the email, so they shouldn't stay locked out
+	err = h.lockout.Unlock(u.Email)
+	if err != nil {
+		h.log.WithError(err).Info()
+	}
+
+	web.Respond(w, r, nil, http.StatusNoContent)
+}
+
+// ChangePassword sets a new password for the calling user after checking their current password.
+// Every other session of the user is signed out.
+func (h *Handler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	// Parse the content of the form
+	err := r.ParseForm()
+	if err != nil {
+		h.log.WithError(err)
+		web.RespondWithCodedError(w, r, http.StatusBadRequest, "bad request", err)
+		return
+	}
+
+	current := r.FormValue("current_password")
+	pass := r.FormValue("new_password")
+	if current == "" || pass == "" {
+		web.RespondWithCodedError(w, r, http.StatusBadRequest, "missing current or new password", errors.New("change password: missing current or new password"))
+		return
+	}
+
+	u, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	// Guessing the current password is limited the same way as logging in
+	ip := clientIP(r)
+	if !h.attemptPassword(w, r, u.Email, ip, "change password") {
+		return
+	}
+
+	if !encryption.Compare(u.Password, current) {
+		h.log.WithField("ip", ip).Info("change password: invalid password")
+		web.RespondWithCodedError(w, r, http.StatusForbidden, "invalid password", errors.New("change password: invalid password"))
+		return
+	}
+
+	err = h.lockout.Succeed(u.Email, ip)
+	if err != nil {
+		h.log.WithError(err).Info()
+	}
+
+	if !h.validPassword(w, r, pass, u.Email) {
+		return
+	}
+
+	err = h.users.Update(r.Context(), u.ID, 0, user.Changes{Password: &pass})
+	if err != nil {
+		h.log.WithError(err).Info()
+		h.respondServerError(w, r, err, "change password")
+		return
+	}
+
+	// Keep the session the password was changed from
+	claims, _ := auth.ClaimsFromContext(r.Context())
+	err = h.auth.RevokeOtherSessions(claims.Subject, claims.SessionID)
+	if err != nil {
+		h.log.WithError(err).Info()
+		h.respondServerError(w, r, err, "change password")
+		return
+	}
+
+	web.Respond(w, r, nil, http.StatusNoContent)
+}
+
+// validPassword responds with every rule the password breaks and returns false if it can't be used by the user with the email.
+func (h *Handler) validPassword(w http.ResponseWriter, r *http.Request, pass, email string) bool {
+	err := h.passwords.Validate(pass, email)
+	if err == nil {
+		return true
+	}
+
+	if verr, ok := err.(*password.ValidationError); ok {
+		web.RespondWithDetailedError(w, r, http.StatusUnprocessableEntity, "invalid password", verr.Violations, err)
+		return false
+	}
+
+	h.log.WithError(err).Info()
+	h.respondServerError(w, r, err, "validate password")
+	return false
+}
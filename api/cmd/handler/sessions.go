@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/jongschneider/youtube-project/api/internal/platform/auth"
+	"github.com/jongschneider/youtube-project/api/internal/platform/web"
+	"github.com/pkg/errors"
+)
+
+type session struct {
+	auth.Session
+
+	// Current is set on the session the request was made with.
+	Current bool `json:"current"`
+}
+
+type sessionsResponse struct {
+	web.Response
+	Sessions []session `json:"sessions"`
+}
+
+// Sessions lists the calling user's active sessions
+func (h *Handler) Sessions(w http.ResponseWriter, r *http.Request) {
+	claims, ok := h.sessionClaims(w, r)
+	if !ok {
+		return
+	}
+
+	sessions, err := h.auth.Sessions(claims.Subject)
+	if err != nil {
+		h.log.WithError(err).Info()
+		h.respondServerError(w, r, err, "sessions")
+		return
+	}
+
+	response := sessionsResponse{
+		Response: web.Response{
+			Message: "success",
+		},
+		Sessions: make([]session, 0, len(sessions)),
+	}
+	for _, s := range sessions {
+		response.Sessions = append(response.Sessions, session{
+			Session: s,
+			Current: s.ID == claims.SessionID,
+		})
+	}
+
+	web.Respond(w, r, response, http.StatusOK)
+}
+
+// RevokeSession signs the calling user out of one of their sessions
+func (h *Handler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	claims, ok := h.sessionClaims(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.auth.RevokeSession(claims.Subject, chi.URLParam(r, "sessionID"))
+	if err != nil {
+		h.log.WithError(err).Info()
+		if errors.Cause(err) == auth.ErrSessionNotFound {
+			web.RespondWithCodedError(w, r, http.StatusNotFound, "session does not exist", errors.Wrap(err, "revoke session"))
+			return
+		}
+
+		h.respondServerError(w, r, err, "revoke session")
+		return
+	}
+
+	web.Respond(w, r, nil, http.StatusNoContent)
+}
+
+// RevokeOtherSessions signs the calling user out of every session except the one the request was made with
+func (h *Handler) RevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	claims, ok := h.sessionClaims(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.auth.RevokeOtherSessions(claims.Subject, claims.SessionID)
+	if err != nil {
+		h.log.WithError(err).Info()
+		h.respondServerError(w, r, err, "revoke other sessions")
+		return
+	}
+
+	web.Respond(w, r, nil, http.StatusNoContent)
+}
+
+// sessionClaims returns the claims of the token used to make the request, as long as it was issued to a user.
+// If it wasn't, it responds with an error and returns false.
+func (h *Handler) sessionClaims(w http.ResponseWriter, r *http.Request) (auth.Claims, bool) {
+	claims, ok := auth.ClaimsFromContext(r.Context())
+	if !ok {
+		web.RespondWithCodedError(w, r, http.StatusUnauthorized, "not authorized", auth.ErrMissingToken)
+		return auth.Claims{}, false
+	}
+
+	_, err := claims.UserID()
+	if err != nil {
+		web.RespondWithCodedError(w, r, http.StatusForbidden, "token is not issued to a user", errors.Wrap(err, "session claims"))
+		return auth.Claims{}, false
+	}
+
+	return claims, true
+}
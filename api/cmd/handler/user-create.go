@@ -9,6 +9,11 @@ import (
 	"github.com/pkg/errors"
 )
 
+type createRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
 type createResponse struct {
 	web.Response
 }
@@ -16,8 +21,8 @@ type createResponse struct {
 // Create create a user with a username and password
 func (h *Handler) Create(w http.ResponseWriter, r *http.Request) {
 	// Parse the content of the form
-	var target user.User
-	err := json.NewDecoder(r.Body).Decode(&target)
+	var req createRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
 	defer r.Body.Close()
 	if err != nil {
 		h.log.WithError(err).Info()
@@ -25,15 +30,41 @@ func (h *Handler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if req.Email == "" {
+		web.RespondWithCodedError(w, r, http.StatusBadRequest, "missing email", errors.New("create: missing email"))
+		return
+	}
+
+	if !h.validPassword(w, r, req.Password, req.Email) {
+		return
+	}
+
+	target := user.User{
+		Email:    req.Email,
+		Password: req.Password,
+	}
+
 	// Go out to the db and try to get the hashed password associated with the provided email
-	err = user.Insert(h.db, target)
+	target.ID, err = h.users.Insert(r.Context(), target)
 	if err != nil {
+		if errors.Cause(err) == user.ErrEmailInUse {
+			h.log.WithError(err).Info()
+			web.RespondWithCodedError(w, r, http.StatusConflict, "email already in use", errors.Wrap(err, "create"))
+			return
+		}
+
 		// Something else went wrong
 		h.log.WithError(err).Info()
-		web.RespondWithCodedError(w, r, http.StatusInternalServerError, "", errors.Wrap(err, "create"))
+		h.respondServerError(w, r, err, "create")
 		return
 	}
 
+	// The user exists either way, so a failed email only gets logged; a new link can be asked for later
+	err = h.sendVerificationEmail(target)
+	if err != nil {
+		h.log.WithError(err).Info()
+	}
+
 	web.Respond(w, r, createResponse{
 		Response: web.Response{
 			Message: "success",
@@ -6,7 +6,6 @@ import (
 	"strconv"
 
 	"github.com/go-chi/chi"
-	"github.com/jongschneider/youtube-project/api/internal/platform/user"
 	"github.com/jongschneider/youtube-project/api/internal/platform/web"
 	"github.com/pkg/errors"
 )
@@ -15,7 +14,7 @@ type deleteResponse struct {
 	web.Response
 }
 
-// Delete deletes a user with a username and password
+// Delete deletes a user. Deleted users can be restored until they are purged
 func (h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
 	// Parse the content of the url
 	id := chi.URLParam(r, "ID")
@@ -26,7 +25,7 @@ func (h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// Go out to the db and try to get the hashed password associated with the provided email
-	u, err := user.GetByID(h.db, userID)
+	u, err := h.users.GetByID(r.Context(), userID)
 
 	if err != nil {
 		// The user was not in the db
@@ -38,15 +37,26 @@ func (h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
 
 		// Something else went wrong
 		h.log.WithError(err).Info()
-		web.RespondWithCodedError(w, r, http.StatusInternalServerError, "", errors.Wrap(err, "login"))
+		h.respondServerError(w, r, err, "login")
 		return
 	}
 
-	err = user.Delete(h.db, u.ID)
+	version, ok := h.ifMatch(w, r, u)
+	if !ok {
+		return
+	}
+
+	err = h.users.Delete(r.Context(), u.ID, version)
+	if err != nil {
+		h.respondWriteError(w, r, err, "delete")
+		return
+	}
+
+	// A deleted user shouldn't stay signed in anywhere
+	err = h.auth.RevokeAllForSubject(strconv.Itoa(u.ID))
 	if err != nil {
-		// Something else went wrong
 		h.log.WithError(err).Info()
-		web.RespondWithCodedError(w, r, http.StatusInternalServerError, "", errors.Wrap(err, "delete"))
+		h.respondServerError(w, r, err, "delete")
 		return
 	}
 
@@ -1,8 +1,12 @@
 package handler
 
 import (
-	"database/sql"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/jongschneider/youtube-project/api/internal/platform/user"
 	"github.com/jongschneider/youtube-project/api/internal/platform/web"
@@ -11,31 +15,107 @@ import (
 
 type getAllResponse struct {
 	web.Response
-	Users []user.User `json:"users,omitempty"`
+	Users []user.User `json:"users"`
+	Next  string      `json:"next,omitempty"`
+	Total *int        `json:"total,omitempty"`
 }
 
-// GetAllUsers gets all users
+// GetAllUsers gets a page of users.
+//
+// The page is selected with the query parameters:
+//   - limit: the most users in the page
+//   - after: the cursor of the previous page, from its next field or Link header
+//   - email_prefix: only users whose email starts with it
+//   - created_after, created_before: only users created within them (RFC 3339)
+//   - sort: id, email or created_at, with a leading - for descending order
+//   - count: true to include the total number of matching users
 func (h *Handler) GetAllUsers(w http.ResponseWriter, r *http.Request) {
-	// Go out to the db and try to get the hashed password associated with the provided email
-	users, err := user.GetAll(h.db)
+	opts, err := listOptions(r.URL.Query())
 	if err != nil {
-		// The user was not in the db
-		if err == sql.ErrNoRows {
-			h.log.WithError(err).Info()
-			web.RespondWithCodedError(w, r, http.StatusBadRequest, "users do not exist", errors.Wrap(err, "get all users"))
-			return
-		}
+		h.log.WithError(err).Info()
+		web.RespondWithCodedError(w, r, http.StatusBadRequest, err.Error(), errors.Wrap(err, "get all users"))
+		return
+	}
 
-		// Something else went wrong
+	// Go out to the db and get the page of users
+	page, err := h.users.List(r.Context(), opts)
+	if err != nil {
 		h.log.WithError(err).Info()
-		web.RespondWithCodedError(w, r, http.StatusInternalServerError, "", errors.Wrap(err, "get all users"))
+		switch errors.Cause(err) {
+		case user.ErrInvalidCursor, user.ErrInvalidSort:
+			web.RespondWithCodedError(w, r, http.StatusBadRequest, err.Error(), errors.Wrap(err, "get all users"))
+		default:
+			h.respondServerError(w, r, err, "get all users")
+		}
 		return
 	}
 
+	// Link to the first page and, if there is one, the next page (RFC 8288)
+	links := []string{pageLink(r.URL, "", "first")}
+	if page.Next != "" {
+		links = append(links, pageLink(r.URL, page.Next, "next"))
+	}
+	w.Header().Set("Link", strings.Join(links, ", "))
+
 	web.Respond(w, r, getAllResponse{
 		Response: web.Response{
 			Message: "success",
 		},
-		Users: users,
+		Users: page.Users,
+		Next:  page.Next,
+		Total: page.Total,
 	}, http.StatusOK)
 }
+
+// listOptions reads the options for a page of users from the query.
+func listOptions(q url.Values) (user.ListOptions, error) {
+	opts := user.ListOptions{
+		After:       q.Get("after"),
+		EmailPrefix: q.Get("email_prefix"),
+		Sort:        q.Get("sort"),
+	}
+
+	var err error
+
+	if v := q.Get("limit"); v != "" {
+		opts.Limit, err = strconv.Atoi(v)
+		if err != nil || opts.Limit <= 0 {
+			return user.ListOptions{}, errors.New("invalid limit")
+		}
+	}
+
+	if v := q.Get("created_after"); v != "" {
+		opts.CreatedAfter, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return user.ListOptions{}, errors.New("invalid created_after")
+		}
+	}
+
+	if v := q.Get("created_before"); v != "" {
+		opts.CreatedBefore, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return user.ListOptions{}, errors.New("invalid created_before")
+		}
+	}
+
+	if v := q.Get("count"); v != "" {
+		opts.Count, err = strconv.ParseBool(v)
+		if err != nil {
+			return user.ListOptions{}, errors.New("invalid count")
+		}
+	}
+
+	return opts, nil
+}
+
+// pageLink returns a Link header value for the same request starting after the cursor.
+func pageLink(u *url.URL, after, rel string) string {
+	q := u.Query()
+	q.Del("after")
+	if after != "" {
+		q.Set("after", after)
+	}
+
+	link := url.URL{Path: u.Path, RawQuery: q.Encode()}
+	return fmt.Sprintf(`<%s>; rel="%s"`, link.String(), rel)
+}
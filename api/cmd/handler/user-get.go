@@ -27,7 +27,7 @@ func (h *Handler) GetUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// Go out to the db and try to get the hashed password associated with the provided email
-	u, err := user.GetByID(h.db, userID)
+	u, err := h.users.GetByID(r.Context(), userID)
 
 	if err != nil {
 		// The user was not in the db
@@ -39,10 +39,11 @@ func (h *Handler) GetUser(w http.ResponseWriter, r *http.Request) {
 
 		// Something else went wrong
 		h.log.WithError(err).Info()
-		web.RespondWithCodedError(w, r, http.StatusInternalServerError, "", errors.Wrap(err, "login"))
+		h.respondServerError(w, r, err, "login")
 		return
 	}
 
+	w.Header().Set("ETag", userETag(u))
 	web.Respond(w, r, getResponse{
 		Response: web.Response{
 			Message: "success",
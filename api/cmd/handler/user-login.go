@@ -2,9 +2,14 @@ package handler
 
 import (
 	"database/sql"
+	"net"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/jongschneider/youtube-project/api/internal/platform/auth"
 	"github.com/jongschneider/youtube-project/api/internal/platform/encryption"
+	"github.com/jongschneider/youtube-project/api/internal/platform/lockout"
 	"github.com/jongschneider/youtube-project/api/internal/platform/user"
 	"github.com/jongschneider/youtube-project/api/internal/platform/web"
 	"github.com/pkg/errors"
@@ -12,6 +17,14 @@ import (
 
 type loginResponse struct {
 	web.Response
+	Token        string `json:"token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresIn    int64  `json:"expires_in,omitempty"`
+
+	// MFARequired is set when the password was correct, but the MFA token still has to be
+	// exchanged for a token at /auth/login/mfa along with a second factor.
+	MFARequired bool   `json:"mfa_required,omitempty"`
+	MFAToken    string `json:"mfa_token,omitempty"`
 }
 
 // Login lets a user login with a username and password
@@ -26,42 +39,140 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 
 	email := r.FormValue("email")
 	pass := r.FormValue("password")
+	ip := clientIP(r)
+
+	// Make sure the email and IP haven't failed too many times recently.
+	// The attempt counts as a failure until the password is found to be right.
+	if !h.attemptPassword(w, r, email, ip, "login") {
+		return
+	}
 
 	// Go out to the db and try to get the hashed password associated with the provided email
-	u, err := user.GetByEmail(h.db, email)
+	u, err := h.users.GetByEmail(r.Context(), email)
 	if err != nil {
-		// The email was not in the db
+		// The email was not in the db.
+		// Still do the work of comparing a password so this can't be told apart from a wrong password.
 		if err == sql.ErrNoRows {
-			h.log.WithError(err).Info()
-			web.RespondWithCodedError(w, r, http.StatusBadRequest, "email does not exist", errors.Wrap(err, "login"))
+			encryption.CompareDummy(pass)
+			h.loginFailed(w, r, email, ip, errors.Wrap(err, "login"))
 			return
 		}
 
 		// Something else went wrong
 		h.log.WithError(err).Info()
-		web.RespondWithCodedError(w, r, http.StatusInternalServerError, "", errors.Wrap(err, "login"))
+		h.respondServerError(w, r, err, "login")
 		return
 	}
 
 	// Compare the hashed password we had in the db with a hashed version of the password the user provided.
 	// If they are the same, we have a match!!!
 	if !encryption.Compare(u.Password, pass) {
+		h.loginFailed(w, r, email, ip, errors.New("login: invalid password"))
+		return
+	}
+
+	err = h.lockout.Succeed(email, ip)
+	if err != nil {
 		h.log.WithError(err).Info()
-		web.RespondWithCodedError(w, r, http.StatusBadRequest, "invalid email/password", errors.Wrap(err, "login"))
+	}
+
+	// Now that we know the password, replace a hash made with outdated settings.
+	// The login doesn't depend on it, so a failure is only logged.
+	// Only the version that was checked is rehashed, so a password changed in the meantime isn't overwritten.
+	if encryption.NeedsRehash(u.Password) {
+		err = h.users.Update(r.Context(), u.ID, u.Version, user.Changes{Password: &pass})
+		if err != nil && errors.Cause(err) != user.ErrVersionMismatch {
+			h.log.WithError(err).Info("rehash password")
+		}
+	}
+
+	if !h.requireVerifiedEmail(w, r, u) {
 		return
 	}
 
-	// token, err := jwtSVC.New(h.key, u.ID)
-	// if err != nil {
-	// 	h.log.WithError(err).Info()
-	// 	web.RespondWithCodedError(w, r, http.StatusInternalServerError, "could not issue JWT token", errors.Wrap(err, "login"))
-	// 	return
-	// }
+	claims := auth.NewUserClaims(u.ID, u.Email, u.Scopes())
+
+	// Users with MFA enabled get a challenge instead of a token
+	if u.TOTPEnabled {
+		challenge, err := h.auth.NewMFAChallenge(claims)
+		if err != nil {
+			h.log.WithError(err).Info()
+			web.RespondWithCodedError(w, r, http.StatusInternalServerError, "could not create mfa challenge", errors.Wrap(err, "login"))
+			return
+		}
+
+		web.Respond(w, r, loginResponse{
+			Response: web.Response{
+				Message: "mfa required",
+			},
+			MFARequired: true,
+			MFAToken:    challenge,
+		}, http.StatusOK)
+		return
+	}
+
+	pair, err := h.auth.NewTokenPair(claims, auth.DeviceFromRequest(r))
+	if err != nil {
+		h.log.WithError(err).Info()
+		web.RespondWithCodedError(w, r, http.StatusInternalServerError, "could not issue JWT token", errors.Wrap(err, "login"))
+		return
+	}
 
 	web.Respond(w, r, loginResponse{
 		Response: web.Response{
 			Message: "success",
 		},
-		// Token: token,
+		Token:        pair.AccessToken,
+		RefreshToken: pair.RefreshToken,
+		ExpiresIn:    pair.ExpiresIn,
 	}, http.StatusOK)
 }
+
+// attemptPassword records an attempt to use the email's password from the IP.
+// It responds with an error and returns false if the email or IP have failed too many times recently.
+func (h *Handler) attemptPassword(w http.ResponseWriter, r *http.Request, email, ip, action string) bool {
+	wait, err := h.lockout.Attempt(email, ip)
+	if err == nil {
+		return true
+	}
+
+	h.log.WithError(err).WithField("ip", ip).Info()
+	switch errors.Cause(err) {
+	case lockout.ErrLocked:
+		// A lockout that doesn't expire has nothing to wait for
+		if wait != lockout.UntilUnlocked {
+			w.Header().Set("Retry-After", retryAfter(wait))
+		}
+		web.RespondWithCodedError(w, r, http.StatusLocked, "account locked", errors.Wrap(err, action))
+	case lockout.ErrBackoff:
+		w.Header().Set("Retry-After", retryAfter(wait))
+		web.RespondWithCodedError(w, r, http.StatusTooManyRequests, "too many attempts", errors.Wrap(err, action))
+	default:
+		h.respondServerError(w, r, err, action)
+	}
+
+	return false
+}
+
+// loginFailed sends the same response whether the email exists or not.
+// The failure was already counted by the lockout attempt.
+func (h *Handler) loginFailed(w http.ResponseWriter, r *http.Request, email, ip string, err error) {
+	h.log.WithError(err).WithField("ip", ip).Info()
+
+	web.RespondWithCodedError(w, r, http.StatusBadRequest, "invalid email/password", err)
+}
+
+// clientIP returns the IP of the client the request came from.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// retryAfter formats a duration as the number of seconds for a Retry-After header, rounding up.
+func retryAfter(d time.Duration) string {
+	return strconv.Itoa(int((d + time.Second - 1) / time.Second))
+}
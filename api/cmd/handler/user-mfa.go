@@ -0,0 +1,305 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/jongschneider/youtube-project/api/internal/platform/auth"
+	"github.com/jongschneider/youtube-project/api/internal/platform/totp"
+	"github.com/jongschneider/youtube-project/api/internal/platform/user"
+	"github.com/jongschneider/youtube-project/api/internal/platform/web"
+	"github.com/pkg/errors"
+)
+
+type enrollTOTPResponse struct {
+	web.Response
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type confirmTOTPResponse struct {
+	web.Response
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// LoginMFA completes a login for a user with MFA enabled by exchanging the MFA token from Login
+// and either a TOTP code or a recovery code for a token
+func (h *Handler) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	// Parse the content of the form
+	err := r.ParseForm()
+	if err != nil {
+		h.log.WithError(err)
+		web.RespondWithCodedError(w, r, http.StatusBadRequest, "bad request", err)
+		return
+	}
+
+	challenge := r.FormValue("mfa_token")
+	code := r.FormValue("code")
+	recoveryCode := r.FormValue("recovery_code")
+
+	claims, err := h.auth.MFAChallengeClaims(challenge)
+	if err != nil {
+		h.log.WithError(err).Info()
+		if errors.Cause(err) == auth.ErrInvalidMFAChallenge {
+			web.RespondWithCodedError(w, r, http.StatusUnauthorized, "invalid mfa token", errors.Wrap(err, "login mfa"))
+			return
+		}
+
+		h.respondServerError(w, r, err, "login mfa")
+		return
+	}
+
+	userID, err := claims.UserID()
+	if err != nil {
+		h.log.WithError(err).Info()
+		web.RespondWithCodedError(w, r, http.StatusUnauthorized, "invalid mfa token", errors.Wrap(err, "login mfa"))
+		return
+	}
+
+	// Go out to the db and get the user's TOTP secret
+	u, err := h.users.GetByID(r.Context(), userID)
+	if err != nil {
+		h.log.WithError(err).Info()
+		h.respondServerError(w, r, err, "login mfa")
+		return
+	}
+
+	var valid bool
+	switch {
+	case code != "":
+		valid, err = h.useTOTPCode(r, u, code)
+		if err != nil {
+			h.log.WithError(err).Info()
+			h.respondServerError(w, r, err, "login mfa")
+			return
+		}
+	case recoveryCode != "":
+		valid, err = h.users.UseRecoveryCode(r.Context(), u.ID, recoveryCode)
+		if err != nil {
+			h.log.WithError(err).Info()
+			h.respondServerError(w, r, err, "login mfa")
+			return
+		}
+	}
+
+	if !valid {
+		err = h.auth.FailMFAChallenge(challenge)
+		if err != nil {
+			h.log.WithError(err).Info()
+		}
+
+		web.RespondWithCodedError(w, r, http.StatusUnauthorized, "invalid code", errors.New("login mfa: invalid code"))
+		return
+	}
+
+	pair, err := h.auth.CompleteMFAChallenge(challenge, auth.DeviceFromRequest(r))
+	if err != nil {
+		h.log.WithError(err).Info()
+		if errors.Cause(err) == auth.ErrInvalidMFAChallenge {
+			web.RespondWithCodedError(w, r, http.StatusUnauthorized, "invalid mfa token", errors.Wrap(err, "login mfa"))
+			return
+		}
+
+		web.RespondWithCodedError(w, r, http.StatusInternalServerError, "could not issue JWT token", errors.Wrap(err, "login mfa"))
+		return
+	}
+
+	web.Respond(w, r, loginResponse{
+		Response: web.Response{
+			Message: "success",
+		},
+		Token:        pair.AccessToken,
+		RefreshToken: pair.RefreshToken,
+		ExpiresIn:    pair.ExpiresIn,
+	}, http.StatusOK)
+}
+
+// EnrollTOTP starts TOTP enrollment for the calling user by generating a new secret
+func (h *Handler) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	// Re-enrolling would turn MFA off until the new secret is confirmed, so MFA has to be disabled first
+	if u.TOTPEnabled {
+		web.RespondWithCodedError(w, r, http.StatusConflict, "mfa already enabled", errors.New("enroll totp: already enabled"))
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		h.log.WithError(err).Info()
+		h.respondServerError(w, r, err, "enroll totp")
+		return
+	}
+
+	err = h.users.SetTOTPSecret(r.Context(), u.ID, secret)
+	if err != nil {
+		h.respondTOTPWriteError(w, r, err, "enroll totp")
+		return
+	}
+
+	web.Respond(w, r, enrollTOTPResponse{
+		Response: web.Response{
+			Message: "success",
+		},
+		Secret: secret,
+		URI:    totp.URI(h.auth.Issuer(), u.Email, secret),
+	}, http.StatusOK)
+}
+
+// TOTPQRCode responds with a PNG QR code of the calling user's pending TOTP secret
+func (h *Handler) TOTPQRCode(w http.ResponseWriter, r *http.Request) {
+	u, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	// The secret is only shown while enrollment is pending
+	if u.TOTPSecret == "" || u.TOTPEnabled {
+		web.RespondWithCodedError(w, r, http.StatusNotFound, "no pending enrollment", errors.New("totp qr code: no pending enrollment"))
+		return
+	}
+
+	png, err := totp.QRCode(totp.URI(h.auth.Issuer(), u.Email, u.TOTPSecret), 256)
+	if err != nil {
+		h.log.WithError(err).Info()
+		h.respondServerError(w, r, err, "totp qr code")
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(png)
+}
+
+// ConfirmTOTP finishes TOTP enrollment once the calling user proves their authenticator works
+func (h *Handler) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	if u.TOTPSecret == "" || u.TOTPEnabled {
+		web.RespondWithCodedError(w, r, http.StatusConflict, "no pending enrollment", errors.New("confirm totp: no pending enrollment"))
+		return
+	}
+
+	valid, err := h.useTOTPCode(r, u, r.FormValue("code"))
+	if err != nil {
+		h.log.WithError(err).Info()
+		h.respondServerError(w, r, err, "confirm totp")
+		return
+	}
+
+	if !valid {
+		web.RespondWithCodedError(w, r, http.StatusBadRequest, "invalid code", errors.New("confirm totp: invalid code"))
+		return
+	}
+
+	codes, err := h.users.EnableTOTP(r.Context(), u.ID)
+	if err != nil {
+		h.respondTOTPWriteError(w, r, err, "confirm totp")
+		return
+	}
+
+	web.Respond(w, r, confirmTOTPResponse{
+		Response: web.Response{
+			Message: "success",
+		},
+		RecoveryCodes: codes,
+	}, http.StatusOK)
+}
+
+// DisableTOTP turns MFA off for the calling user once they provide a current TOTP code
+func (h *Handler) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	if !u.TOTPEnabled {
+		web.RespondWithCodedError(w, r, http.StatusBadRequest, "invalid code", errors.New("disable totp: not enabled"))
+		return
+	}
+
+	valid, err := h.useTOTPCode(r, u, r.FormValue("code"))
+	if err != nil {
+		h.log.WithError(err).Info()
+		h.respondServerError(w, r, err, "disable totp")
+		return
+	}
+
+	if !valid {
+		web.RespondWithCodedError(w, r, http.StatusBadRequest, "invalid code", errors.New("disable totp: invalid code"))
+		return
+	}
+
+	err = h.users.DisableTOTP(r.Context(), u.ID)
+	if err != nil {
+		h.respondTOTPWriteError(w, r, err, "disable totp")
+		return
+	}
+
+	web.Respond(w, r, nil, http.StatusNoContent)
+}
+
+// respondTOTPWriteError responds to an error changing the calling user's MFA settings.
+// The user may have been deleted since the request started, which is treated like it is by currentUser.
+func (h *Handler) respondTOTPWriteError(w http.ResponseWriter, r *http.Request, err error, action string) {
+	h.log.WithError(err).Info()
+
+	if err == sql.ErrNoRows {
+		web.RespondWithCodedError(w, r, http.StatusUnauthorized, "user does not exist", errors.Wrap(err, action))
+		return
+	}
+
+	h.respondServerError(w, r, err, action)
+}
+
+// useTOTPCode returns true if the code is valid for the user's TOTP secret and hasn't been used before.
+// Using a code also uses up every code before it, so an intercepted code can't be replayed.
+func (h *Handler) useTOTPCode(r *http.Request, u user.User, code string) (bool, error) {
+	counter, ok := totp.Match(u.TOTPSecret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	return h.users.UseTOTPCounter(r.Context(), u.ID, counter)
+}
+
+// currentUser looks up the user the request's token was issued to.
+// If there isn't one, an error response is sent and false is returned.
+func (h *Handler) currentUser(w http.ResponseWriter, r *http.Request) (user.User, bool) {
+	claims, ok := auth.ClaimsFromContext(r.Context())
+	if !ok {
+		web.RespondWithCodedError(w, r, http.StatusUnauthorized, "not authorized", auth.ErrMissingToken)
+		return user.User{}, false
+	}
+
+	userID, err := claims.UserID()
+	if err != nil {
+		web.RespondWithCodedError(w, r, http.StatusForbidden, "token is not issued to a user", errors.Wrap(err, "current user"))
+		return user.User{}, false
+	}
+
+	// Go out to the db and get the user the token was issued to
+	u, err := h.users.GetByID(r.Context(), userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			h.log.WithError(err).Info()
+			web.RespondWithCodedError(w, r, http.StatusUnauthorized, "user does not exist", errors.Wrap(err, "current user"))
+			return user.User{}, false
+		}
+
+		// Something else went wrong
+		h.log.WithError(err).Info()
+		h.respondServerError(w, r, err, "current user")
+		return user.User{}, false
+	}
+
+	return u, true
+}
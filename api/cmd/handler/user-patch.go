@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/jongschneider/youtube-project/api/internal/platform/user"
+	"github.com/jongschneider/youtube-project/api/internal/platform/web"
+	"github.com/pkg/errors"
+)
+
+type patchResponse struct {
+	web.Response
+	User user.User `json:"user"`
+}
+
+// Patch partially updates a user using a JSON Merge Patch (RFC 7396).
+// Only the fields in the patch are changed. A null role resets it to the default role.
+func (h *Handler) Patch(w http.ResponseWriter, r *http.Request) {
+	// Parse the content of the url
+	id := chi.URLParam(r, "ID")
+	userID, err := strconv.Atoi(id)
+	if err != nil {
+		h.log.WithError(err)
+		web.RespondWithCodedError(w, r, http.StatusBadRequest, "bad request", err)
+		return
+	}
+
+	// Parse the content of the patch
+	patch := map[string]json.RawMessage{}
+	err = json.NewDecoder(r.Body).Decode(&patch)
+	defer r.Body.Close()
+	if err != nil {
+		h.log.WithError(err).Info()
+		web.RespondWithCodedError(w, r, http.StatusBadRequest, "malformed request", errors.Wrap(err, "decode"))
+		return
+	}
+
+	changes, err := userChanges(patch)
+	if err != nil {
+		h.log.WithError(err).Info()
+		web.RespondWithCodedError(w, r, http.StatusBadRequest, err.Error(), errors.Wrap(err, "patch"))
+		return
+	}
+
+	// Go out to the db and make sure the user exists
+	current, err := h.users.GetByID(r.Context(), userID)
+	if err != nil {
+		// The user was not in the db
+		if err == sql.ErrNoRows {
+			h.log.WithError(err).Info()
+			web.RespondWithCodedError(w, r, http.StatusBadRequest, "user does not exist", errors.Wrap(err, "get user"))
+			return
+		}
+
+		// Something else went wrong
+		h.log.WithError(err).Info()
+		h.respondServerError(w, r, err, "patch")
+		return
+	}
+
+	version, ok := h.ifMatch(w, r, current)
+	if !ok {
+		return
+	}
+
+	err = h.users.Update(r.Context(), userID, version, changes)
+	if err != nil {
+		h.respondWriteError(w, r, err, "patch")
+		return
+	}
+
+	u, err := h.users.GetByID(r.Context(), userID)
+	if err != nil {
+		h.log.WithError(err).Info()
+		h.respondServerError(w, r, err, "patch")
+		return
+	}
+
+	w.Header().Set("ETag", userETag(u))
+	web.Respond(w, r, patchResponse{
+		Response: web.Response{
+			Message: "success",
+		},
+		User: u,
+	}, http.StatusOK)
+}
+
+// userChanges turns the members of a merge patch into the changes to make to a user.
+// Passwords can't be patched; they have to be changed with the current password.
+func userChanges(patch map[string]json.RawMessage) (user.Changes, error) {
+	changes := user.Changes{}
+
+	for field, raw := range patch {
+		null := string(raw) == "null"
+
+		switch field {
+		case "email":
+			if null {
+				return user.Changes{}, errors.New("email can't be removed")
+			}
+
+			var email string
+			err := json.Unmarshal(raw, &email)
+			if err != nil || email == "" {
+				return user.Changes{}, errors.New("invalid email")
+			}
+			changes.Email = &email
+
+		case "role":
+			role := user.RoleUser
+			if !null {
+				err := json.Unmarshal(raw, &role)
+				if err != nil {
+					return user.Changes{}, errors.New("invalid role")
+				}
+			}
+			changes.Role = &role
+
+		default:
+			return user.Changes{}, errors.Errorf("%s can't be patched", field)
+		}
+	}
+
+	return changes, nil
+}
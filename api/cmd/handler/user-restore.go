@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/jongschneider/youtube-project/api/internal/platform/user"
+	"github.com/jongschneider/youtube-project/api/internal/platform/web"
+	"github.com/pkg/errors"
+)
+
+// Restore brings back a deleted user that hasn't been purged yet
+func (h *Handler) Restore(w http.ResponseWriter, r *http.Request) {
+	// Parse the content of the url
+	id := chi.URLParam(r, "ID")
+	userID, err := strconv.Atoi(id)
+	if err != nil {
+		h.log.WithError(err)
+		web.RespondWithCodedError(w, r, http.StatusBadRequest, "bad request", err)
+		return
+	}
+
+	err = h.users.Restore(r.Context(), userID)
+	if err != nil {
+		// There is no deleted user to restore
+		if err == sql.ErrNoRows {
+			h.log.WithError(err).Info()
+			web.RespondWithCodedError(w, r, http.StatusBadRequest, "deleted user does not exist", errors.Wrap(err, "restore"))
+			return
+		}
+
+		// Someone else has the user's email now
+		if err == user.ErrEmailInUse {
+			h.log.WithError(err).Info()
+			web.RespondWithCodedError(w, r, http.StatusConflict, "email already in use", errors.Wrap(err, "restore"))
+			return
+		}
+
+		// Something else went wrong
+		h.log.WithError(err).Info()
+		h.respondServerError(w, r, err, "restore")
+		return
+	}
+
+	web.Respond(w, r, nil, http.StatusNoContent)
+}
@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/jongschneider/youtube-project/api/internal/platform/web"
+	"github.com/pkg/errors"
+)
+
+// Unlock clears the failed logins and lockout of a user's account
+func (h *Handler) Unlock(w http.ResponseWriter, r *http.Request) {
+	// Parse the content of the url
+	id := chi.URLParam(r, "ID")
+	userID, err := strconv.Atoi(id)
+	if err != nil {
+		h.log.WithError(err)
+		web.RespondWithCodedError(w, r, http.StatusBadRequest, "bad request", err)
+		return
+	}
+
+	// Go out to the db and get the email the failures are tracked by
+	u, err := h.users.GetByID(r.Context(), userID)
+	if err != nil {
+		// The user was not in the db
+		if err == sql.ErrNoRows {
+			h.log.WithError(err).Info()
+			web.RespondWithCodedError(w, r, http.StatusBadRequest, "user does not exist", errors.Wrap(err, "get user"))
+			return
+		}
+
+		// Something else went wrong
+		h.log.WithError(err).Info()
+		h.respondServerError(w, r, err, "unlock")
+		return
+	}
+
+	err = h.lockout.Unlock(u.Email)
+	if err != nil {
+		// Something else went wrong
+		h.log.WithError(err).Info()
+		h.respondServerError(w, r, err, "unlock")
+		return
+	}
+
+	web.Respond(w, r, nil, http.StatusNoContent)
+}
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"database/sql"
 	"encoding/json"
 	"net/http"
 	"strconv"
@@ -11,6 +12,11 @@ import (
 	"github.com/pkg/errors"
 )
 
+type updateRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
 type updateResponse struct {
 	web.Response
 }
@@ -28,8 +34,8 @@ func (h *Handler) Update(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Parse the content of the form
-	target := user.User{}
-	err = json.NewDecoder(r.Body).Decode(&target)
+	req := updateRequest{}
+	err = json.NewDecoder(r.Body).Decode(&req)
 	defer r.Body.Close()
 	if err != nil {
 		h.log.WithError(err).Info()
@@ -37,13 +43,44 @@ func (h *Handler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	target.ID = userID
-	// Go out to the db and try to get the hashed password associated with the provided email
-	err = user.Update(h.db, target)
+	if req.Email == "" {
+		web.RespondWithCodedError(w, r, http.StatusBadRequest, "missing email", errors.New("update: missing email"))
+		return
+	}
+
+	// The password is only replaced when a new one is provided
+	if req.Password != "" && !h.validPassword(w, r, req.Password, req.Email) {
+		return
+	}
+
+	// Go out to the db and make sure the user exists
+	current, err := h.users.GetByID(r.Context(), userID)
 	if err != nil {
+		// The user was not in the db
+		if err == sql.ErrNoRows {
+			h.log.WithError(err).Info()
+			web.RespondWithCodedError(w, r, http.StatusBadRequest, "user does not exist", errors.Wrap(err, "get user"))
+			return
+		}
+
 		// Something else went wrong
 		h.log.WithError(err).Info()
-		web.RespondWithCodedError(w, r, http.StatusInternalServerError, "", errors.Wrap(err, "update"))
+		h.respondServerError(w, r, err, "update")
+		return
+	}
+
+	version, ok := h.ifMatch(w, r, current)
+	if !ok {
+		return
+	}
+
+	changes := user.Changes{Email: &req.Email}
+	if req.Password != "" {
+		changes.Password = &req.Password
+	}
+	err = h.users.Update(r.Context(), userID, version, changes)
+	if err != nil {
+		h.respondWriteError(w, r, err, "update")
 		return
 	}
 
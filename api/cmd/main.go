@@ -16,6 +16,13 @@ import (
 	"github.com/jongschneider/youtube-project/api/internal/platform/cache"
 	"github.com/jongschneider/youtube-project/api/internal/platform/config"
 	"github.com/jongschneider/youtube-project/api/internal/platform/database"
+	"github.com/jongschneider/youtube-project/api/internal/platform/encryption"
+	"github.com/jongschneider/youtube-project/api/internal/platform/env"
+	"github.com/jongschneider/youtube-project/api/internal/platform/lockout"
+	"github.com/jongschneider/youtube-project/api/internal/platform/mail"
+	"github.com/jongschneider/youtube-project/api/internal/platform/migrate"
+	"github.com/jongschneider/youtube-project/api/internal/platform/password"
+	"github.com/jongschneider/youtube-project/api/internal/platform/user"
 	"github.com/jongschneider/youtube-project/api/internal/platform/web"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -41,20 +48,53 @@ func init() {
 
 	log.WithFields(cfg.LogFields()).Info("Startup Config")
 
+	err = encryption.Configure(cfg.HashConfig)
+	if err != nil {
+		log.WithError(err).Fatal("encryption: configure")
+	}
+
+	err = user.ConfigureRecoveryCodes(cfg.RecoveryCodeKey)
+	if err != nil {
+		log.WithError(err).Fatal("user: configure recovery codes")
+	}
+
 }
 
 func main() {
 
 	db := database.New(cfg.DBConfig)
+	db.PublishStats("db")
+	if cfg.MigrateConfig.ApplyOnStartup(cfg.AppConfig.Env == env.Local) {
+		migrateDB(db)
+	}
 	cacheSVC := cache.New(cfg.CacheConfig)
 	authSVC := getAuthClient(cacheSVC)
+	mailer, err := mail.New(cfg.MailConfig)
+	if err != nil {
+		log.WithError(err).Fatal("mail: new")
+	}
+	// Passwords have to fit in what the hash algorithm can use
+	cfg.PasswordConfig.MaxBytes = encryption.MaxPasswordBytes()
+	passwords, err := password.New(cfg.PasswordConfig)
+	if err != nil {
+		log.WithError(err).Fatal("password: new")
+	}
+
 	// Create a handler
 	h := handler.New(
 		handler.Config{
-			DB:    db,
-			Cache: cacheSVC,
-			Auth:  authSVC,
-			Log:   log,
+			DB:      db,
+			Users:   user.NewSQLStore(db),
+			Cache:   cacheSVC,
+			Auth:    authSVC,
+			Lockout: lockout.New(cacheSVC, cfg.LockoutConfig),
+			Mailer:  mailer,
+			Log:     log,
+
+			Passwords:            passwords,
+			PasswordResetTTL:     cfg.PasswordResetTTL,
+			EmailVerificationTTL: cfg.EmailVerificationTTL,
+			RequireIfMatch:       cfg.RequireIfMatch,
 		})
 
 	// Create a new server with all of the routes attached to the server's handler
@@ -70,12 +110,24 @@ func main() {
 		},
 	}
 
+	// Verify client certificates when there are CAs to verify them against
+	if cfg.AuthConfig.ClientCAFile != "" {
+		srv.TLSConfig.ClientCAs = mustLoadCertPool(cfg.AuthConfig.ClientCAFile)
+		srv.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
 	// Gracefully handle shutdowns
 	go shutdown(srv, time.Second*30)
 
+	// Rotate the signing key whenever the auth key is replaced
+	go reloadAuthKey(authSVC)
+
+	// Permanently remove deleted users once they can no longer be restored
+	go purgeUsers(db, cfg.UserRetention, cfg.UserPurgeInterval)
+
 	// Start the server listening for requests.
 	log.Printf("listening on port%s", srv.Addr)
-	err := srv.ListenAndServeTLS("", "")
+	err = srv.ListenAndServeTLS("", "")
 	if err != nil && err != http.ErrServerClosed {
 		log.Fatalln(errors.Wrap(err, "start server"))
 	}
@@ -97,12 +149,130 @@ func shutdown(srv *http.Server, timeout time.Duration) {
 	}
 }
 
+// reloadAuthKey reloads the auth service's keys every time the process receives a SIGHUP.
+// The retiring keys are accepted from then on, and the signing key is rotated if it changed.
+// Tokens signed with the previous key stay valid for the auth KeyRetention.
+// Only this process is affected, so every replica has to be sent a SIGHUP; see auth/keys.go for the steps.
+func reloadAuthKey(a *auth.Service) {
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+
+	for range reload {
+		for _, fp := range cfg.AuthConfig.RetiringKeyFiles {
+			key, err := loadFromFile(fp)
+			if err != nil {
+				log.WithError(err).Error("auth: reload retiring key")
+				continue
+			}
+
+			kid, err := a.TrustKey(key)
+			if err != nil {
+				log.WithError(err).Error("auth: trust key")
+				continue
+			}
+
+			log.WithField("kid", kid).Info("auth: trusted key")
+		}
+
+		key, err := loadAuthKey()
+		if err != nil {
+			log.WithError(err).Error("auth: reload key")
+			continue
+		}
+
+		kid, err := a.RotateKey(key)
+		if err != nil {
+			log.WithError(err).Error("auth: rotate key")
+			continue
+		}
+
+		log.WithField("kid", kid).Info("auth: rotated key")
+	}
+}
+
+// migrateDB applies every pending migration, waiting for any other replica that is migrating to finish first.
+func migrateDB(db *database.DB) {
+	m, err := migrate.New(db, cfg.MigrateConfig)
+	if err != nil {
+		log.WithError(err).Fatal("migrate: new")
+	}
+
+	applied, err := m.Up()
+	for _, mig := range applied {
+		log.WithField("migration", mig.String()).Info("migrate: applied")
+	}
+	if err != nil {
+		log.WithError(err).Fatal("migrate: up")
+	}
+}
+
+// purgeUsers permanently removes users that were deleted longer than retention ago, every interval.
+func purgeUsers(db *database.DB, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		n, err := user.Purge(context.Background(), db, time.Now().UTC().Add(-retention))
+		if err != nil {
+			log.WithError(err).Error("user: purge")
+			continue
+		}
+
+		if n > 0 {
+			log.WithField("count", n).Info("user: purged deleted users")
+		}
+	}
+}
+
+// requestValidators returns the validators for every kind of credential that has been configured.
+func requestValidators(c *redis.Client) []auth.RequestValidator {
+	validators := []auth.RequestValidator{}
+
+	if len(cfg.AuthConfig.APIKeys) > 0 {
+		validators = append(validators, auth.APIKeyValidator("X-API-Key", cfg.AuthConfig.APIKeys))
+	}
+
+	if len(cfg.AuthConfig.HMACKeys) > 0 {
+		validators = append(validators, auth.HMACValidator(auth.HMACConfig{
+			Keys:  cfg.AuthConfig.HMACKeys,
+			Cache: c,
+		}))
+	}
+
+	if len(cfg.AuthConfig.AllowedCIDRs) > 0 {
+		v, err := auth.CIDRValidator(cfg.AuthConfig.AllowedCIDRs)
+		if err != nil {
+			log.WithError(err).Fatal("auth: cidr validator")
+		}
+		validators = append(validators, v)
+	}
+
+	if len(cfg.AuthConfig.ClientCertSubjects) > 0 {
+		validators = append(validators, auth.ClientCertValidator(cfg.AuthConfig.ClientCertSubjects))
+	}
+
+	return validators
+}
+
 func getAuthClient(c *redis.Client) *auth.Service {
+	// Email verification links are signed by the auth service too, so rotated keys have to outlive them
+	retention := cfg.AuthConfig.KeyRetention
+	if cfg.EmailVerificationTTL > retention {
+		retention = cfg.EmailVerificationTTL
+	}
+
 	return auth.New(auth.Config{
-		Issuer:            cfg.AuthConfig.Issuer,
-		PrivateKey:        mustLoadAuthKey(),
-		Enforce:           cfg.AuthConfig.Enforce,
-		RequestValidators: []auth.RequestValidator{},
+		Issuer:               cfg.AuthConfig.Issuer,
+		Audience:             cfg.AuthConfig.Audience,
+		Algorithm:            cfg.AuthConfig.Algorithm,
+		TTL:                  cfg.AuthConfig.TTL,
+		RefreshTTL:           cfg.AuthConfig.RefreshTTL,
+		KeyRetention:         retention,
+		PrivateKey:           mustLoadAuthKey(),
+		RetiringKeys:         mustLoadRetiringAuthKeys(cfg.AuthConfig.RetiringKeyFiles),
+		Enforce:              cfg.AuthConfig.Enforce,
+		RequireVerifiedEmail: cfg.AuthConfig.RequireVerifiedEmail,
+		RequestValidators:    requestValidators(c),
 		AbortRequest: func(w http.ResponseWriter, r *http.Request, err error, statusCode int) {
 			log.WithError(err).WithFields(logrus.Fields{
 				"statuscode": statusCode,
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/jongschneider/youtube-project/api/internal/platform/config"
+	"github.com/jongschneider/youtube-project/api/internal/platform/database"
+	"github.com/jongschneider/youtube-project/api/internal/platform/env"
+	"github.com/jongschneider/youtube-project/api/internal/platform/migrate"
+	"github.com/sirupsen/logrus"
+)
+
+const usage = `usage: migrate <command>
+
+commands:
+  up            apply every pending migration
+  down [n]      roll back the last n migrations (default 1)
+  to <version>  apply or roll back migrations until version is the last one applied
+  status        list every migration and when it was applied
+  seed          add development data, such as an admin user (APP_ENV=local only)`
+
+func main() {
+	log := logrus.New()
+	config.SetLogrusFormatter(log)
+
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var cfg config.Base
+	err := config.Load(&cfg)
+	if err != nil {
+		log.WithError(err).Fatal("config: load")
+	}
+
+	m, err := migrate.New(database.New(cfg.DBConfig), cfg.MigrateConfig)
+	if err != nil {
+		log.WithError(err).Fatal("migrate: new")
+	}
+
+	var done []migrate.Migration
+	switch cmd := os.Args[1]; cmd {
+	case "up":
+		done, err = m.Up()
+	case "down":
+		steps := 1
+		if len(os.Args) > 2 {
+			steps, err = strconv.Atoi(os.Args[2])
+			if err != nil || steps < 1 {
+				log.Fatalf("migrate: invalid number of steps: %s", os.Args[2])
+			}
+		}
+		done, err = m.Down(steps)
+	case "to":
+		if len(os.Args) < 3 {
+			fmt.Fprintln(os.Stderr, usage)
+			os.Exit(2)
+		}
+
+		version, perr := strconv.ParseInt(os.Args[2], 10, 64)
+		if perr != nil {
+			log.Fatalf("migrate: invalid version: %s", os.Args[2])
+		}
+		done, err = m.To(version)
+	case "status":
+		err = printStatus(m)
+	case "seed":
+		// Seeds have well known credentials, so they must never reach a shared database
+		if cfg.AppConfig.Env != env.Local {
+			log.Fatalf("migrate: seed is only allowed when APP_ENV=%s", env.Local)
+		}
+
+		var seeded []string
+		seeded, err = m.Seed()
+		for _, name := range seeded {
+			log.WithField("seed", name).Info("migrate: seed")
+		}
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	for _, mig := range done {
+		log.WithField("migration", mig.String()).Info("migrate: " + os.Args[1])
+	}
+
+	if err != nil {
+		log.WithError(err).Fatal("migrate: " + os.Args[1])
+	}
+}
+
+// printStatus prints a table of every migration and when it was applied.
+func printStatus(m *migrate.Migrator) error {
+	statuses, err := m.Status()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "MIGRATION\tAPPLIED AT")
+	for _, s := range statuses {
+		applied := "pending"
+		if s.AppliedAt != nil {
+			applied = s.AppliedAt.UTC().Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%s\t%s\n", s.Migration, applied)
+	}
+
+	return w.Flush()
+}
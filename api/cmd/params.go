@@ -2,6 +2,7 @@ package main
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"io/ioutil"
 
 	"github.com/pkg/errors"
@@ -77,6 +78,20 @@ func loadLocalCert() (string, string, error) {
 	return cert, key, nil
 }
 
+func mustLoadCertPool(fp string) *x509.CertPool {
+	pem, err := loadFromFile(fp)
+	if err != nil {
+		panic(errors.Wrap(err, "load client ca"))
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM([]byte(pem)) {
+		panic(errors.Errorf("no certificates found in %s", fp))
+	}
+
+	return pool
+}
+
 type authKeyFunc func() (string, error)
 
 func loadAuthKey() (string, error) {
@@ -114,3 +129,17 @@ func loadLocalKey() (string, error) {
 
 	return key, nil
 }
+
+func mustLoadRetiringAuthKeys(files []string) []string {
+	keys := []string{}
+	for _, fp := range files {
+		key, err := loadFromFile(fp)
+		if err != nil {
+			panic(errors.Wrap(err, "load retiring auth key"))
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys
+}
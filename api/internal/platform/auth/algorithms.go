@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// ErrUnsupportedAlgorithm is the error returned when a signing algorithm isn't supported by the Service.
+var ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+
+// SigningMethodEdDSA implements the EdDSA signing method from RFC 8037 using Ed25519 keys.
+// Expects ed25519.PrivateKey for signing and ed25519.PublicKey for verification.
+type SigningMethodEdDSA struct{}
+
+// SigningMethodEd25519 is the EdDSA signing method. It is registered with jwt-go so tokens using it can be parsed.
+var SigningMethodEd25519 = &SigningMethodEdDSA{}
+
+func init() {
+	jwt.RegisterSigningMethod(SigningMethodEd25519.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEd25519
+	})
+}
+
+// Alg implements jwt.SigningMethod.
+func (m *SigningMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+// Verify implements jwt.SigningMethod.
+func (m *SigningMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(pub, []byte(signingString), sig) {
+		return errors.New("ed25519: verification error")
+	}
+
+	return nil
+}
+
+// Sign implements jwt.SigningMethod.
+func (m *SigningMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+
+	return jwt.EncodeSegment(ed25519.Sign(private, []byte(signingString))), nil
+}
+
+// signingMethods are the algorithms that can be selected with Config.Algorithm.
+var signingMethods = map[string]jwt.SigningMethod{
+	jwt.SigningMethodRS256.Alg(): jwt.SigningMethodRS256,
+	jwt.SigningMethodRS384.Alg(): jwt.SigningMethodRS384,
+	jwt.SigningMethodRS512.Alg(): jwt.SigningMethodRS512,
+	jwt.SigningMethodPS256.Alg(): jwt.SigningMethodPS256,
+	jwt.SigningMethodPS384.Alg(): jwt.SigningMethodPS384,
+	jwt.SigningMethodPS512.Alg(): jwt.SigningMethodPS512,
+	jwt.SigningMethodES256.Alg(): jwt.SigningMethodES256,
+	jwt.SigningMethodES384.Alg(): jwt.SigningMethodES384,
+	jwt.SigningMethodES512.Alg(): jwt.SigningMethodES512,
+	SigningMethodEd25519.Alg():   SigningMethodEd25519,
+}
+
+// ecdsaCurves are the curves required by each ECDSA algorithm.
+var ecdsaCurves = map[string]elliptic.Curve{
+	jwt.SigningMethodES256.Alg(): elliptic.P256(),
+	jwt.SigningMethodES384.Alg(): elliptic.P384(),
+	jwt.SigningMethodES512.Alg(): elliptic.P521(),
+}
+
+// signingMethod returns the jwt.SigningMethod for the provided algorithm name.
+func signingMethod(alg string) (jwt.SigningMethod, error) {
+	m, ok := signingMethods[alg]
+	if !ok {
+		return nil, errors.Wrap(ErrUnsupportedAlgorithm, alg)
+	}
+
+	return m, nil
+}
+
+// acceptsAlgorithm returns true if a token signed with alg can be verified with the public key.
+func acceptsAlgorithm(pub crypto.PublicKey, alg string) bool {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		switch signingMethods[alg].(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+			return true
+		}
+	case *ecdsa.PublicKey:
+		curve, ok := ecdsaCurves[alg]
+		return ok && curve == k.Curve
+	case ed25519.PublicKey:
+		return alg == SigningMethodEd25519.Alg()
+	}
+
+	return false
+}
+
+// publicKey returns the public half of a private key parsed by parsePrivateKeyFromPEM.
+func publicKey(private crypto.PrivateKey) crypto.PublicKey {
+	switch k := private.(type) {
+	case *rsa.PrivateKey:
+		return &k.PublicKey
+	case *ecdsa.PrivateKey:
+		return &k.PublicKey
+	case ed25519.PrivateKey:
+		return k.Public()
+	}
+
+	return nil
+}
+
+// parsePrivateKeyFromPEM parses a PEM-encoded RSA, ECDSA or Ed25519 private key.
+// PKCS #1, SEC 1 and PKCS #8 encodings are accepted.
+func parsePrivateKeyFromPEM(key string) (crypto.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(key))
+	if block == nil {
+		return nil, errors.New("key must be PEM encoded")
+	}
+
+	if k, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return k, nil
+	}
+
+	if k, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return k, nil
+	}
+
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse private key")
+	}
+
+	switch k.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey:
+		return k, nil
+	}
+
+	return nil, errors.Errorf("unsupported private key type: %T", k)
+}
+
+// parsePublicKeyFromPEM accepts either a PEM-encoded private key or public key and returns the public key.
+func parsePublicKeyFromPEM(key string) (crypto.PublicKey, error) {
+	private, err := parsePrivateKeyFromPEM(key)
+	if err == nil {
+		return publicKey(private), nil
+	}
+
+	block, _ := pem.Decode([]byte(key))
+	if block == nil {
+		return nil, errors.New("key must be PEM encoded")
+	}
+
+	if k, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return k, nil
+	}
+
+	k, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse public key")
+	}
+
+	switch k.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		return k, nil
+	}
+
+	return nil, errors.Errorf("unsupported public key type: %T", k)
+}
+
+// parseSigningKeyFromPEM parses a PEM-encoded private key and checks it can be used with the signing method.
+func parseSigningKeyFromPEM(key string, method jwt.SigningMethod) (crypto.PrivateKey, error) {
+	private, err := parsePrivateKeyFromPEM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if !acceptsAlgorithm(publicKey(private), method.Alg()) {
+		return nil, errors.Errorf("%T can't be used with %s", private, method.Alg())
+	}
+
+	return private, nil
+}
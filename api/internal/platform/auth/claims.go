@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+var claimsKey authCtxKey = "claims"
+
+// clientSubjectPrefix is prepended to a client's ID to make its subject, so a client's subject
+// can never be the same as a user's, which are their numeric IDs.
+const clientSubjectPrefix = "client:"
+
+// Claims are the claims carried by every JWT issued by the Service.
+type Claims struct {
+	Email string `json:"email,omitempty"`
+
+	// Scope is the space-delimited list of scopes granted to the token, as in RFC 8693.
+	Scope string `json:"scope,omitempty"`
+
+	// ClientID is the OAuth 2.0 client the token was issued to.
+	ClientID string `json:"client_id,omitempty"`
+
+	// SessionID is the session the token was issued in. Only tokens issued with a refresh token have one.
+	SessionID string `json:"sid,omitempty"`
+	jwt.StandardClaims
+}
+
+// NewUserClaims returns the Claims identifying the provided user and granting them the provided scopes.
+func NewUserClaims(userID int, email string, scopes []string) Claims {
+	return Claims{
+		Email: email,
+		Scope: strings.Join(scopes, " "),
+		StandardClaims: jwt.StandardClaims{
+			Subject: strconv.Itoa(userID),
+		},
+	}
+}
+
+// NewClientClaims returns the Claims identifying the provided OAuth 2.0 client and granting it the provided scopes.
+// The client is also the subject, as there is no user involved, but its subject is prefixed so its tokens
+// and sessions are never indexed together with those of a user.
+func NewClientClaims(clientID string, scopes []string) Claims {
+	return Claims{
+		Scope:    strings.Join(scopes, " "),
+		ClientID: clientID,
+		StandardClaims: jwt.StandardClaims{
+			Subject: clientSubjectPrefix + clientID,
+		},
+	}
+}
+
+// UserID returns the ID of the user the claims were issued to.
+// It returns an error if the token wasn't issued to a user.
+func (c Claims) UserID() (int, error) {
+	if c.Subject == "" || c.ClientID != "" {
+		return 0, errors.New("no subject")
+	}
+
+	id, err := strconv.Atoi(c.Subject)
+	if err != nil {
+		return 0, errors.Wrap(err, "parse subject")
+	}
+
+	return id, nil
+}
+
+// Scopes returns the scopes granted to the token.
+func (c Claims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// HasScope returns true if the token was granted the provided scope.
+func (c Claims) HasScope(scope string) bool {
+	for _, s := range c.Scopes() {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ClaimsFromContext returns the claims of the token that authenticated the request.
+// It returns false if the request wasn't authenticated by RequireValidToken.
+func ClaimsFromContext(ctx context.Context) (Claims, bool) {
+	c, ok := ctx.Value(claimsKey).(Claims)
+	return c, ok
+}
+
+// newTokenID returns a random ID suitable for the jti claim.
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
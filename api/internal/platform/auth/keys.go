@@ -0,0 +1,308 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/jongschneider/youtube-project/api/internal/platform/web"
+	"github.com/pkg/errors"
+)
+
+/*
+	The key set lives in the memory of each process, so a rotation has to be made on every replica.
+	Replicas that don't have a key yet reject the tokens signed with it, so a new key is rolled out in two steps:
+
+	1. Add the new key to AUTH_RETIRING_KEY_FILES and send every replica a SIGHUP. They all accept the key now.
+	2. Replace auth.pem with the new key and send every replica a SIGHUP. They all sign with it now, and keep
+	   accepting the old key for KeyRetention.
+
+	Once every token signed with the old key has expired, remove it from AUTH_RETIRING_KEY_FILES if it is there.
+*/
+
+// ErrUnknownKey is the error returned when a token was signed with a key that isn't in the key set.
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// signingKey is a key identified by its kid.
+// Keys that are only used for verification don't have a private half, and retiring keys loaded
+// from the config don't have a method since the algorithm they were used with isn't known.
+type signingKey struct {
+	id      string
+	method  jwt.SigningMethod
+	private crypto.PrivateKey
+	public  crypto.PublicKey
+
+	// expiresAt is when a retired key can be dropped from the set.
+	// A zero value means the key is kept until it is removed explicitly.
+	expiresAt time.Time
+}
+
+// keySet holds the key new tokens are signed with and the retiring keys that are still
+// accepted when verifying tokens that were signed before a rotation.
+type keySet struct {
+	mu     sync.RWMutex
+	active *signingKey
+	keys   map[string]*signingKey
+}
+
+// newSigningKey returns the signingKey for the private key used with the signing method.
+func newSigningKey(method jwt.SigningMethod, private crypto.PrivateKey) *signingKey {
+	pub := publicKey(private)
+	return &signingKey{id: thumbprint(pub), method: method, private: private, public: pub}
+}
+
+// newKeySet returns a keySet that signs with the provided key and also verifies with the retiring keys.
+func newKeySet(active *signingKey, retiring []crypto.PublicKey) *keySet {
+	ks := &keySet{
+		keys: map[string]*signingKey{},
+	}
+
+	for _, pub := range retiring {
+		k := &signingKey{id: thumbprint(pub), public: pub}
+		ks.keys[k.id] = k
+	}
+
+	ks.keys[active.id] = active
+	ks.active = active
+
+	return ks
+}
+
+// signer returns the key new tokens should be signed with.
+func (ks *keySet) signer() *signingKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	return ks.active
+}
+
+// verifier returns the public key with the provided kid, provided it can verify tokens signed with alg.
+// Tokens issued before kids were added don't have one, so they are checked against the active key.
+func (ks *keySet) verifier(kid, alg string) (crypto.PublicKey, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	k := ks.active
+	if kid != "" {
+		var ok bool
+		k, ok = ks.keys[kid]
+		if !ok || (!k.expiresAt.IsZero() && time.Now().After(k.expiresAt)) {
+			return nil, ErrUnknownKey
+		}
+	}
+
+	// Keys that have been used for signing only verify tokens signed with that algorithm.
+	// This prevents a token from being verified with a different algorithm than it was issued with.
+	if k.method != nil && k.method.Alg() != alg {
+		return nil, errors.Errorf("unexpected signing method: %s", alg)
+	}
+
+	if !acceptsAlgorithm(k.public, alg) {
+		return nil, errors.Errorf("unexpected signing method: %s", alg)
+	}
+
+	return k.public, nil
+}
+
+// rotate makes the provided key the active key. The previous active key is kept for verification until retainFor has elapsed.
+func (ks *keySet) rotate(next *signingKey, retainFor time.Duration) string {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	now := time.Now()
+	for id, k := range ks.keys {
+		if !k.expiresAt.IsZero() && now.After(k.expiresAt) {
+			delete(ks.keys, id)
+		}
+	}
+
+	if next.id == ks.active.id {
+		return next.id
+	}
+
+	ks.active.expiresAt = now.Add(retainFor)
+	ks.keys[next.id] = next
+	ks.active = next
+
+	return next.id
+}
+
+// trust adds the public key to the set so the tokens it signed are accepted, and returns its kid.
+// A key that is already in the set is left as it is.
+func (ks *keySet) trust(pub crypto.PublicKey) string {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	k := &signingKey{id: thumbprint(pub), public: pub}
+	if _, ok := ks.keys[k.id]; !ok {
+		ks.keys[k.id] = k
+	}
+
+	return k.id
+}
+
+// retire removes the key with the provided kid from the set. The active key can't be retired.
+func (ks *keySet) retire(kid string) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if kid == ks.active.id {
+		return errors.New("can't retire the active key")
+	}
+
+	delete(ks.keys, kid)
+	return nil
+}
+
+// jwks returns the public half of every key in the set.
+func (ks *keySet) jwks() JWKS {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	set := JWKS{Keys: []JWK{}}
+	now := time.Now()
+	for _, k := range ks.keys {
+		if !k.expiresAt.IsZero() && now.After(k.expiresAt) {
+			continue
+		}
+
+		set.Keys = append(set.Keys, newJWK(k))
+	}
+
+	return set
+}
+
+// JWK is the JSON Web Key representation of a public key as defined in RFC 7517 and RFC 8037.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg,omitempty"`
+	KeyID     string `json:"kid"`
+
+	// RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC and OKP keys
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set as defined in RFC 7517.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+func newJWK(k *signingKey) JWK {
+	jwk := publicJWK(k.public)
+	jwk.Use = "sig"
+	jwk.KeyID = k.id
+	if k.method != nil {
+		jwk.Algorithm = k.method.Alg()
+	}
+
+	return jwk
+}
+
+// publicJWK returns the key type and key material of the public key.
+func publicJWK(pub crypto.PublicKey) JWK {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			KeyType: "RSA",
+			N:       base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+			E:       base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		}
+	case *ecdsa.PublicKey:
+		// Coordinates are padded to the size of the curve as required by RFC 7518.
+		size := (k.Curve.Params().BitSize + 7) / 8
+		return JWK{
+			KeyType: "EC",
+			Curve:   k.Curve.Params().Name,
+			X:       base64.RawURLEncoding.EncodeToString(padLeft(k.X.Bytes(), size)),
+			Y:       base64.RawURLEncoding.EncodeToString(padLeft(k.Y.Bytes(), size)),
+		}
+	case ed25519.PublicKey:
+		return JWK{
+			KeyType: "OKP",
+			Curve:   "Ed25519",
+			X:       base64.RawURLEncoding.EncodeToString(k),
+		}
+	}
+
+	return JWK{}
+}
+
+func padLeft(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+
+	padded := make([]byte, size)
+	copy(padded[size-len(b):], b)
+	return padded
+}
+
+// thumbprint returns the RFC 7638 JWK thumbprint of the public key, which is used as its kid.
+// Every replica holding the same key derives the same kid.
+func thumbprint(pub crypto.PublicKey) string {
+	jwk := publicJWK(pub)
+
+	var canonical string
+	switch jwk.KeyType {
+	case "RSA":
+		canonical = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, jwk.E, jwk.N)
+	case "EC":
+		canonical = fmt.Sprintf(`{"crv":"%s","kty":"EC","x":"%s","y":"%s"}`, jwk.Curve, jwk.X, jwk.Y)
+	case "OKP":
+		canonical = fmt.Sprintf(`{"crv":"%s","kty":"OKP","x":"%s"}`, jwk.Curve, jwk.X)
+	}
+
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// RotateKey makes the PEM-encoded private key the key new tokens are signed with and returns its kid.
+// The key must be usable with the service's signing algorithm.
+// The previously active key is still accepted for verification for the service's KeyRetention.
+// Only this process signs with the new key; see the top of this file for rotating every replica.
+func (s *Service) RotateKey(privateKey string) (string, error) {
+	key, err := parseSigningKeyFromPEM(privateKey, s.method)
+	if err != nil {
+		return "", errors.Wrap(err, "parse private key")
+	}
+
+	return s.keys.rotate(newSigningKey(s.method, key), s.keyRetention), nil
+}
+
+// TrustKey accepts tokens signed with the PEM-encoded key (private or public) from now on, and returns its kid.
+// Tokens aren't signed with it until it is rotated in with RotateKey.
+func (s *Service) TrustKey(key string) (string, error) {
+	pub, err := parsePublicKeyFromPEM(key)
+	if err != nil {
+		return "", errors.Wrap(err, "parse key")
+	}
+
+	return s.keys.trust(pub), nil
+}
+
+// RetireKey stops accepting tokens signed with the key with the provided kid.
+func (s *Service) RetireKey(kid string) error {
+	return s.keys.retire(kid)
+}
+
+// JWKSHandler is the http.Handler that publishes the public keys tokens can be verified with.
+func (s *Service) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	web.Respond(w, r, s.keys.jwks(), http.StatusOK)
+}
@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+)
+
+func TestRotateKeyRetainsOldKey(t *testing.T) {
+	s := New(Config{
+		Algorithm:    "EdDSA",
+		PrivateKey:   newTestKey(t),
+		Cache:        redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()}),
+		TTL:          time.Hour,
+		KeyRetention: 24 * time.Hour,
+	})
+
+	old := s.keys.signer().id
+
+	// Email verification tokens outlive access tokens
+	token, err := s.NewEmailVerificationToken("1", "jane@example.com", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kid, err := s.RotateKey(newTestKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid == old {
+		t.Fatal("rotated to the same key")
+	}
+
+	_, err = s.ParseEmailVerificationToken(token)
+	if err != nil {
+		t.Errorf("token signed with the old key: %v", err)
+	}
+
+	// The old key is kept for the retention, not the access token TTL
+	retained := time.Until(s.keys.keys[old].expiresAt)
+	if retained < 23*time.Hour || retained > 24*time.Hour {
+		t.Errorf("old key is retained for %s, want 24h", retained)
+	}
+}
+
+func TestKeyRetentionIsAtLeastTTL(t *testing.T) {
+	s := New(Config{
+		Algorithm:    "EdDSA",
+		PrivateKey:   newTestKey(t),
+		TTL:          2 * time.Hour,
+		KeyRetention: time.Minute,
+	})
+
+	if s.keyRetention != 2*time.Hour {
+		t.Errorf("keyRetention = %s, want %s", s.keyRetention, 2*time.Hour)
+	}
+}
+
+func TestTrustKey(t *testing.T) {
+	cache := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	key := newTestKey(t)
+
+	// Two replicas sharing a cache, one of which has been rotated to a new key
+	rotated := New(Config{Algorithm: "EdDSA", PrivateKey: key, Cache: cache})
+	other := New(Config{Algorithm: "EdDSA", PrivateKey: newTestKey(t), Cache: cache})
+
+	token, err := rotated.NewSignedToken(NewUserClaims(1, "jane@example.com", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = other.ValidateToken(token)
+	if err == nil {
+		t.Fatal("token signed with an unknown key was accepted")
+	}
+
+	kid, err := other.TrustKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid != rotated.keys.signer().id {
+		t.Errorf("kid = %q, want %q", kid, rotated.keys.signer().id)
+	}
+
+	_, err = other.ValidateToken(token)
+	if err != nil {
+		t.Errorf("token signed with a trusted key: %v", err)
+	}
+
+	// Trusting a key doesn't sign with it
+	if other.keys.signer().id == kid {
+		t.Error("trusted key became the signing key")
+	}
+}
@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+const (
+	// mfaChallengeTTL is how long a user has to complete an MFA challenge after entering their password.
+	mfaChallengeTTL = 5 * time.Minute
+
+	// mfaMaxAttempts is how many wrong codes can be entered before the challenge is thrown away.
+	mfaMaxAttempts = 5
+)
+
+// ErrInvalidMFAChallenge is the error returned when an MFA challenge token is unknown, expired or was already completed.
+var ErrInvalidMFAChallenge = errors.New("invalid mfa challenge")
+
+// mfaCacheKey returns the key an MFA challenge is stored under in the cache.
+func (s *Service) mfaCacheKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return fmt.Sprintf("%smfa:%s", s.keyPrefix, hex.EncodeToString(sum[:]))
+}
+
+// NewMFAChallenge returns a short-lived challenge token for a user who has entered their password,
+// but still has to provide a second factor before the claims are issued as a token.
+func (s *Service) NewMFAChallenge(c Claims) (string, error) {
+	token, err := newRefreshToken()
+	if err != nil {
+		return "", errors.Wrap(err, "new challenge token")
+	}
+
+	claims, err := json.Marshal(c)
+	if err != nil {
+		return "", errors.Wrap(err, "marshal claims")
+	}
+
+	key := s.mfaCacheKey(token)
+
+	pipe := s.cache.TxPipeline()
+	pipe.HMSet(key, map[string]interface{}{
+		"claims":   string(claims),
+		"attempts": 0,
+	})
+	pipe.Expire(key, mfaChallengeTTL)
+	_, err = pipe.Exec()
+	if err != nil {
+		return "", errors.Wrap(err, "error persisting mfa challenge to cache")
+	}
+
+	return token, nil
+}
+
+// MFAChallengeClaims returns the claims that will be issued once the challenge is completed.
+func (s *Service) MFAChallengeClaims(token string) (Claims, error) {
+	raw, err := s.cache.HGet(s.mfaCacheKey(token), "claims").Result()
+	if err == redis.Nil {
+		return Claims{}, ErrInvalidMFAChallenge
+	}
+	if err != nil {
+		return Claims{}, errors.Wrap(err, "get mfa challenge")
+	}
+
+	c := Claims{}
+	err = json.Unmarshal([]byte(raw), &c)
+	if err != nil {
+		return Claims{}, errors.Wrap(err, "unmarshal claims")
+	}
+
+	return c, nil
+}
+
+// FailMFAChallenge records a wrong code. Once too many wrong codes have been entered the challenge
+// is thrown away and the user has to start over with their password.
+func (s *Service) FailMFAChallenge(token string) error {
+	key := s.mfaCacheKey(token)
+
+	attempts, err := s.cache.HIncrBy(key, "attempts", 1).Result()
+	if err != nil {
+		return errors.Wrap(err, "increment mfa attempts")
+	}
+
+	if attempts >= mfaMaxAttempts {
+		err = s.cache.Del(key).Err()
+		if err != nil {
+			return errors.Wrap(err, "delete mfa challenge")
+		}
+	}
+
+	return nil
+}
+
+// CompleteMFAChallenge consumes the challenge and issues a TokenPair with its claims, starting a session from the device.
+// A challenge can only be completed once.
+func (s *Service) CompleteMFAChallenge(token string, d Device) (TokenPair, error) {
+	c, err := s.MFAChallengeClaims(token)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	// Only the request that actually deletes the challenge gets to use it.
+	n, err := s.cache.Del(s.mfaCacheKey(token)).Result()
+	if err != nil {
+		return TokenPair{}, errors.Wrap(err, "delete mfa challenge")
+	}
+
+	if n == 0 {
+		return TokenPair{}, ErrInvalidMFAChallenge
+	}
+
+	return s.NewTokenPair(c, d)
+}
@@ -0,0 +1,341 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/jongschneider/youtube-project/api/internal/platform/web"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrInvalidRefreshToken is the error returned when a refresh token is unknown or has expired.
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+	// ErrRefreshTokenReused is the error returned when a refresh token that has already been used is presented again.
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+
+	// ErrWrongClient is the error returned when a client acts on a token that wasn't issued to it.
+	ErrWrongClient = errors.New("token was issued to another client")
+)
+
+// useRefreshToken atomically marks a refresh token as used.
+// It returns -1 if the token doesn't exist, 0 if it was already used and 1 if this is the first use.
+var useRefreshToken = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return -1
+end
+return redis.call('HSETNX', KEYS[1], 'used', ARGV[1])
+`)
+
+// TokenPair is a short-lived access token along with the refresh token that can be used to replace it.
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// refreshCacheKey returns the key a refresh token's record is stored under in the cache.
+// Only a hash of the refresh token is stored so the cache can't be used to mint new tokens.
+func (s *Service) refreshCacheKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return fmt.Sprintf("%srefresh:%s", s.keyPrefix, hex.EncodeToString(sum[:]))
+}
+
+// familyRefreshCacheKey returns the key of the set holding the cache keys of every refresh token in a family.
+func (s *Service) familyRefreshCacheKey(family string) string {
+	return fmt.Sprintf("%sfamily:%s:refresh", s.keyPrefix, family)
+}
+
+// familyAccessCacheKey returns the key of the set holding every access token issued in a family.
+func (s *Service) familyAccessCacheKey(family string) string {
+	return fmt.Sprintf("%sfamily:%s:access", s.keyPrefix, family)
+}
+
+// subjectFamiliesCacheKey returns the key of the set holding every refresh token family started by a subject.
+func (s *Service) subjectFamiliesCacheKey(subject string) string {
+	return fmt.Sprintf("%ssubject:%s:families", s.keyPrefix, subject)
+}
+
+// newRefreshToken returns a new opaque refresh token.
+func newRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// NewTokenPair creates a new access token with the provided claims and a refresh token that starts a new family.
+// Every refresh token issued by rotating this one belongs to the same family.
+// A family is also a session of the subject, started from the provided device.
+func (s *Service) NewTokenPair(c Claims, d Device) (TokenPair, error) {
+	family, err := newTokenID()
+	if err != nil {
+		return TokenPair{}, errors.Wrap(err, "new family id")
+	}
+
+	if c.Subject != "" {
+		key := s.subjectFamiliesCacheKey(c.Subject)
+		session := s.familySessionCacheKey(family)
+		now := time.Now().UTC().Unix()
+
+		pipe := s.cache.TxPipeline()
+		pipe.SAdd(key, family)
+		pipe.Expire(key, s.refreshTTL)
+		pipe.HMSet(session, map[string]interface{}{
+			"user_agent": d.UserAgent,
+			"ip":         d.IP,
+			"issued_at":  now,
+			"last_seen":  now,
+		})
+		pipe.Expire(session, s.refreshTTL)
+		_, err = pipe.Exec()
+		if err != nil {
+			return TokenPair{}, errors.Wrap(err, "index family")
+		}
+	}
+
+	return s.issueTokenPair(family, c)
+}
+
+// issueTokenPair creates a new access token and refresh token belonging to the provided family.
+func (s *Service) issueTokenPair(family string, c Claims) (TokenPair, error) {
+	c.SessionID = family
+
+	access, err := s.NewSignedToken(c)
+	if err != nil {
+		return TokenPair{}, errors.Wrap(err, "new signed token")
+	}
+
+	refresh, err := newRefreshToken()
+	if err != nil {
+		return TokenPair{}, errors.Wrap(err, "new refresh token")
+	}
+
+	claims, err := json.Marshal(c)
+	if err != nil {
+		return TokenPair{}, errors.Wrap(err, "marshal claims")
+	}
+
+	key := s.refreshCacheKey(refresh)
+	familyRefresh := s.familyRefreshCacheKey(family)
+	familyAccess := s.familyAccessCacheKey(family)
+	session := s.familySessionCacheKey(family)
+
+	pipe := s.cache.TxPipeline()
+	pipe.HMSet(key, map[string]interface{}{
+		"family": family,
+		"claims": string(claims),
+	})
+	pipe.Expire(key, s.refreshTTL)
+	pipe.SAdd(familyRefresh, key)
+	pipe.Expire(familyRefresh, s.refreshTTL)
+	pipe.SAdd(familyAccess, access)
+	pipe.Expire(familyAccess, s.refreshTTL)
+	pipe.Expire(session, s.refreshTTL)
+	_, err = pipe.Exec()
+	if err != nil {
+		return TokenPair{}, errors.Wrap(err, "error persisting refresh token to cache")
+	}
+
+	return TokenPair{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(s.ttl / time.Second),
+	}, nil
+}
+
+// Refresh exchanges a refresh token for a new TokenPair. Every refresh token can only be used once.
+// If a refresh token that was already used is presented again, the whole family is revoked, including
+// the access tokens issued with it, and ErrRefreshTokenReused is returned.
+// The family's session is marked as seen from the provided device.
+func (s *Service) Refresh(token string, d Device) (TokenPair, error) {
+	key := s.refreshCacheKey(token)
+
+	res, err := useRefreshToken.Run(s.cache, []string{key}, time.Now().UTC().Unix()).Int()
+	if err != nil {
+		return TokenPair{}, errors.Wrap(err, "use refresh token")
+	}
+
+	switch res {
+	case -1:
+		return TokenPair{}, ErrInvalidRefreshToken
+	case 0:
+		family, err := s.cache.HGet(key, "family").Result()
+		if err != nil && err != redis.Nil {
+			return TokenPair{}, errors.Wrap(err, "get family")
+		}
+
+		if family != "" {
+			err = s.revokeFamily(family)
+			if err != nil {
+				return TokenPair{}, errors.Wrap(err, "revoke family")
+			}
+		}
+
+		return TokenPair{}, ErrRefreshTokenReused
+	}
+
+	vals, err := s.cache.HMGet(key, "family", "claims").Result()
+	if err != nil {
+		return TokenPair{}, errors.Wrap(err, "get refresh token")
+	}
+
+	family, _ := vals[0].(string)
+	raw, _ := vals[1].(string)
+	if family == "" || raw == "" {
+		// The family was revoked between marking the token as used and reading it back.
+		return TokenPair{}, ErrInvalidRefreshToken
+	}
+
+	c := Claims{}
+	err = json.Unmarshal([]byte(raw), &c)
+	if err != nil {
+		return TokenPair{}, errors.Wrap(err, "unmarshal claims")
+	}
+
+	err = s.touchSession(family, d)
+	if err != nil {
+		return TokenPair{}, errors.Wrap(err, "touch session")
+	}
+
+	return s.issueTokenPair(family, c)
+}
+
+// revokeFamily deletes every refresh token in the family and revokes every access token issued with them,
+// which ends the family's session.
+func (s *Service) revokeFamily(family string) error {
+	familyRefresh := s.familyRefreshCacheKey(family)
+	familyAccess := s.familyAccessCacheKey(family)
+
+	refreshKeys, err := s.cache.SMembers(familyRefresh).Result()
+	if err != nil {
+		return errors.Wrap(err, "get family refresh tokens")
+	}
+
+	if len(refreshKeys) > 0 {
+		err = s.cache.Del(refreshKeys...).Err()
+		if err != nil {
+			return errors.Wrap(err, "delete family refresh tokens")
+		}
+	}
+
+	accessTokens, err := s.cache.SMembers(familyAccess).Result()
+	if err != nil {
+		return errors.Wrap(err, "get family access tokens")
+	}
+
+	for _, t := range accessTokens {
+		err = s.RevokeToken(t)
+		if err != nil {
+			return errors.Wrap(err, "revoke family access token")
+		}
+	}
+
+	err = s.cache.Del(familyRefresh, familyAccess, s.familySessionCacheKey(family)).Err()
+	if err != nil {
+		return errors.Wrap(err, "delete family")
+	}
+
+	return nil
+}
+
+// RefreshTokenHandler is the http.Handler that exchanges the refresh_token form value for a new TokenPair.
+func (s *Service) RefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		web.RespondWithCodedError(w, r, http.StatusBadRequest, "bad request", err)
+		return
+	}
+
+	token := r.FormValue("refresh_token")
+	if token == "" {
+		web.RespondWithCodedError(w, r, http.StatusBadRequest, "missing refresh token", ErrInvalidRefreshToken)
+		return
+	}
+
+	pair, err := s.Refresh(token, DeviceFromRequest(r))
+	switch errors.Cause(err) {
+	case nil:
+	case ErrInvalidRefreshToken, ErrRefreshTokenReused:
+		s.tokenBlocked(r, err, http.StatusUnauthorized)
+		web.RespondWithCodedError(w, r, http.StatusUnauthorized, err.Error(), err)
+		return
+	default:
+		s.tokenBlocked(r, err, http.StatusInternalServerError)
+		web.RespondWithCodedError(w, r, http.StatusInternalServerError, ErrGenerateToken.Error(), err)
+		return
+	}
+
+	web.Respond(w, r, pair, http.StatusOK)
+}
+
+// RefreshTokenClaims returns the claims access tokens issued with the refresh token will carry,
+// with ExpiresAt set to when the refresh token expires.
+// It returns ErrInvalidRefreshToken if the token is unknown, expired or has already been used.
+func (s *Service) RefreshTokenClaims(token string) (Claims, error) {
+	key := s.refreshCacheKey(token)
+
+	pipe := s.cache.Pipeline()
+	vals := pipe.HMGet(key, "claims", "used")
+	ttl := pipe.TTL(key)
+	_, err := pipe.Exec()
+	if err != nil && err != redis.Nil {
+		return Claims{}, errors.Wrap(err, "get refresh token")
+	}
+
+	raw, _ := vals.Val()[0].(string)
+	if raw == "" || vals.Val()[1] != nil || ttl.Val() <= 0 {
+		return Claims{}, ErrInvalidRefreshToken
+	}
+
+	c := Claims{}
+	err = json.Unmarshal([]byte(raw), &c)
+	if err != nil {
+		return Claims{}, errors.Wrap(err, "unmarshal claims")
+	}
+
+	c.ExpiresAt = time.Now().Add(ttl.Val()).Unix()
+	return c, nil
+}
+
+// RevokeRefreshToken revokes the refresh token's whole family, including the access tokens issued with it,
+// on behalf of the OAuth 2.0 client with the provided ID.
+// It returns ErrWrongClient if the refresh token wasn't issued to that client, which includes every refresh token
+// issued to a user. Revoking a refresh token that doesn't exist is not an error.
+func (s *Service) RevokeRefreshToken(token, clientID string) error {
+	vals, err := s.cache.HMGet(s.refreshCacheKey(token), "family", "claims").Result()
+	if err != nil {
+		return errors.Wrap(err, "get refresh token")
+	}
+
+	family, _ := vals[0].(string)
+	raw, _ := vals[1].(string)
+	if family == "" || raw == "" {
+		return nil
+	}
+
+	c := Claims{}
+	err = json.Unmarshal([]byte(raw), &c)
+	if err != nil {
+		return errors.Wrap(err, "unmarshal claims")
+	}
+
+	if c.ClientID == "" || c.ClientID != clientID {
+		return ErrWrongClient
+	}
+
+	return s.revokeFamily(family)
+}
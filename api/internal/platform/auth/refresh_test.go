@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func newTestPair(t *testing.T, s *Service, userID int) TokenPair {
+	t.Helper()
+
+	pair, err := s.NewTokenPair(NewUserClaims(userID, "jane@example.com", nil), Device{UserAgent: "test", IP: "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pair
+}
+
+func TestRefreshRotates(t *testing.T) {
+	s := newTestService(t)
+	pair := newTestPair(t, s, 1)
+
+	next, err := s.Refresh(pair.RefreshToken, Device{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if next.RefreshToken == pair.RefreshToken || next.AccessToken == pair.AccessToken {
+		t.Error("refresh returned the same tokens")
+	}
+
+	claims, err := s.ValidateToken(next.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "1" {
+		t.Errorf("subject = %q, want %q", claims.Subject, "1")
+	}
+
+	// The new refresh token can be used in turn
+	_, err = s.Refresh(next.RefreshToken, Device{})
+	if err != nil {
+		t.Errorf("refresh with rotated token: %v", err)
+	}
+}
+
+func TestRefreshReuseRevokesFamily(t *testing.T) {
+	s := newTestService(t)
+	pair := newTestPair(t, s, 1)
+	other := newTestPair(t, s, 1)
+
+	next, err := s.Refresh(pair.RefreshToken, Device{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Presenting the used token again means it was stolen, so the whole family goes
+	_, err = s.Refresh(pair.RefreshToken, Device{})
+	if errors.Cause(err) != ErrRefreshTokenReused {
+		t.Fatalf("err = %v, want %v", err, ErrRefreshTokenReused)
+	}
+
+	_, err = s.Refresh(next.RefreshToken, Device{})
+	if errors.Cause(err) != ErrInvalidRefreshToken {
+		t.Errorf("refresh with the family's latest token: err = %v, want %v", err, ErrInvalidRefreshToken)
+	}
+
+	for name, token := range map[string]string{"first": pair.AccessToken, "rotated": next.AccessToken} {
+		_, err = s.ValidateToken(token)
+		if err == nil {
+			t.Errorf("%s access token of the family is still valid", name)
+		}
+	}
+
+	// Other families of the same user aren't affected
+	_, err = s.ValidateToken(other.AccessToken)
+	if err != nil {
+		t.Errorf("access token of another family: %v", err)
+	}
+
+	_, err = s.Refresh(other.RefreshToken, Device{})
+	if err != nil {
+		t.Errorf("refresh token of another family: %v", err)
+	}
+}
+
+func TestRefreshUnknownToken(t *testing.T) {
+	s := newTestService(t)
+
+	_, err := s.Refresh("unknown", Device{})
+	if errors.Cause(err) != ErrInvalidRefreshToken {
+		t.Errorf("err = %v, want %v", err, ErrInvalidRefreshToken)
+	}
+}
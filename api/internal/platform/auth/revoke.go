@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/jongschneider/youtube-project/api/internal/platform/web"
+	"github.com/pkg/errors"
+)
+
+// revokedValue is stored in place of a token's expiration when the token has been revoked.
+const revokedValue = "revoked"
+
+// tokenCacheKey returns the key a token's record is stored under in the cache.
+func tokenCacheKey(token string) string {
+	return fmt.Sprintf("token:%s", token)
+}
+
+// subjectCacheKey returns the key of the set holding every token issued to the provided subject.
+func (s *Service) subjectCacheKey(subject string) string {
+	return fmt.Sprintf("%ssubject:%s", s.keyPrefix, subject)
+}
+
+// indexToken adds the token to the subject's set of tokens so they can all be revoked at once.
+// The set lives at least as long as the newest token in it.
+func (s *Service) indexToken(subject, token string, exp time.Duration) error {
+	key := s.subjectCacheKey(subject)
+
+	pipe := s.cache.TxPipeline()
+	pipe.SAdd(key, token)
+	ttl := pipe.TTL(key)
+	_, err := pipe.Exec()
+	if err != nil {
+		return err
+	}
+
+	if ttl.Val() < exp {
+		return s.cache.Expire(key, exp).Err()
+	}
+
+	return nil
+}
+
+// checkTokenRecord returns nil if the token has a record in the cache that hasn't been revoked.
+// It returns ErrTokenNotFound if there is no record and ErrTokenRevoked if the record was revoked.
+func (s *Service) checkTokenRecord(token string) error {
+	val, err := s.cache.Get(tokenCacheKey(token)).Result()
+	if err == redis.Nil {
+		return ErrTokenNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if val == revokedValue {
+		return ErrTokenRevoked
+	}
+
+	return nil
+}
+
+// RevokeToken marks the token's record in the cache as revoked so it will be rejected by RequireValidToken.
+// The record keeps its original expiration. Revoking a token that has no record is not an error.
+func (s *Service) RevokeToken(token string) error {
+	key := tokenCacheKey(token)
+
+	ttl, err := s.cache.TTL(key).Result()
+	if err != nil {
+		return errors.Wrap(err, "get token ttl")
+	}
+
+	// The record has already expired (or never existed), so there is nothing left to revoke.
+	if ttl <= 0 {
+		return nil
+	}
+
+	err = s.cache.Set(key, revokedValue, ttl).Err()
+	if err != nil {
+		return errors.Wrap(err, "revoke token")
+	}
+
+	return nil
+}
+
+// RevokeAllForSubject revokes every token that has been issued to the provided subject,
+// including their refresh tokens.
+func (s *Service) RevokeAllForSubject(subject string) error {
+	familiesKey := s.subjectFamiliesCacheKey(subject)
+
+	families, err := s.cache.SMembers(familiesKey).Result()
+	if err != nil {
+		return errors.Wrap(err, "get subject families")
+	}
+
+	for _, f := range families {
+		err = s.revokeFamily(f)
+		if err != nil {
+			return errors.Wrap(err, "revoke subject family")
+		}
+	}
+
+	key := s.subjectCacheKey(subject)
+
+	tokens, err := s.cache.SMembers(key).Result()
+	if err != nil {
+		return errors.Wrap(err, "get subject tokens")
+	}
+
+	for _, t := range tokens {
+		err = s.RevokeToken(t)
+		if err != nil {
+			return errors.Wrap(err, "revoke subject token")
+		}
+	}
+
+	err = s.cache.Del(key, familiesKey).Err()
+	if err != nil {
+		return errors.Wrap(err, "delete subject tokens")
+	}
+
+	return nil
+}
+
+// LogoutHandler is the http.Handler that revokes the token used to make the request.
+// It must be mounted behind RequireValidToken.
+func (s *Service) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	token, ok := r.Context().Value(tokenKey).(string)
+	if !ok || token == "" {
+		web.RespondWithCodedError(w, r, http.StatusUnauthorized, ErrMissingToken.Error(), ErrMissingToken)
+		return
+	}
+
+	err := s.RevokeToken(token)
+	if err != nil {
+		web.RespondWithCodedError(w, r, http.StatusInternalServerError, "could not revoke token", errors.Wrap(err, "logout"))
+		return
+	}
+
+	web.Respond(w, r, nil, http.StatusNoContent)
+}
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestRevokeToken(t *testing.T) {
+	s := newTestService(t)
+
+	token, err := s.NewSignedToken(NewUserClaims(1, "jane@example.com", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := s.NewSignedToken(NewUserClaims(1, "jane@example.com", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.ValidateToken(token)
+	if err != nil {
+		t.Fatalf("new token: %v", err)
+	}
+
+	err = s.RevokeToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.ValidateToken(token)
+	if errors.Cause(err) != ErrTokenRevoked {
+		t.Errorf("err = %v, want %v", err, ErrTokenRevoked)
+	}
+
+	// Only the revoked token is affected
+	_, err = s.ValidateToken(other)
+	if err != nil {
+		t.Errorf("other token: %v", err)
+	}
+
+	// Revoking again, or revoking a token without a record, isn't an error
+	err = s.RevokeToken(token)
+	if err != nil {
+		t.Errorf("revoke again: %v", err)
+	}
+
+	err = s.RevokeToken("unknown")
+	if err != nil {
+		t.Errorf("revoke unknown: %v", err)
+	}
+}
+
+func TestRevokeAllForSubject(t *testing.T) {
+	s := newTestService(t)
+
+	token, err := s.NewSignedToken(NewUserClaims(1, "jane@example.com", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pair, err := s.NewTokenPair(NewUserClaims(1, "jane@example.com", nil), Device{UserAgent: "test", IP: "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherUser, err := s.NewSignedToken(NewUserClaims(2, "john@example.com", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.RevokeAllForSubject("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tok := range map[string]string{"token": token, "paired token": pair.AccessToken} {
+		_, err = s.ValidateToken(tok)
+		if err == nil {
+			t.Errorf("%s is still valid", name)
+		}
+	}
+
+	_, err = s.Refresh(pair.RefreshToken, Device{})
+	if errors.Cause(err) != ErrInvalidRefreshToken {
+		t.Errorf("refresh err = %v, want %v", err, ErrInvalidRefreshToken)
+	}
+
+	_, err = s.ValidateToken(otherUser)
+	if err != nil {
+		t.Errorf("another user's token: %v", err)
+	}
+}
+
+func TestLogoutHandler(t *testing.T) {
+	s := newTestService(t)
+	s.enforce = true
+	s.abortRequest = func(w http.ResponseWriter, r *http.Request, err error, statusCode int) {
+		w.WriteHeader(statusCode)
+	}
+
+	token, err := s.NewSignedToken(NewUserClaims(1, "jane@example.com", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logout := s.RequireValidToken(http.HandlerFunc(s.LogoutHandler))
+	request := func() int {
+		r := httptest.NewRequest(http.MethodPost, "/auth/logout", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		logout.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := request(); code >= 300 {
+		t.Fatalf("status = %d, want success", code)
+	}
+
+	// The token was revoked by logging out, so it can't be used again
+	if code := request(); code != http.StatusUnauthorized {
+		t.Errorf("status after logout = %d, want %d", code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInsufficientScope is the error returned when a token hasn't been granted a scope required by the endpoint.
+var ErrInsufficientScope = errors.New("insufficient scope")
+
+// RequireScope is middleware that requires the token placed in the request's context by RequireValidToken
+// to have been granted every one of the provided scopes.
+// It must be mounted behind RequireValidToken.
+func (s *Service) RequireScope(scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		enforce := s.getEnforcer(next)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := ClaimsFromContext(r.Context())
+			if !ok {
+				enforce(w, r, ErrMissingToken, http.StatusUnauthorized)
+				return
+			}
+
+			for _, scope := range scopes {
+				if !claims.HasScope(scope) {
+					enforce(w, r, errors.Wrap(ErrInsufficientScope, scope), http.StatusForbidden)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
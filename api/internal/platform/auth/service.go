@@ -2,8 +2,7 @@ package auth
 
 import (
 	"context"
-	"crypto/rsa"
-	"fmt"
+	"crypto"
 	"net/http"
 	"strings"
 	"time"
@@ -32,6 +31,15 @@ var (
 
 	// ErrMissingToken is the error returned when there is no token.
 	ErrMissingToken = errors.New("missing token")
+
+	// ErrInvalidToken is the error returned when a token can't be parsed or its claims aren't valid.
+	ErrInvalidToken = errors.New("invalid token")
+
+	// ErrTokenNotFound is the error returned when a token has no record in the cache.
+	ErrTokenNotFound = errors.New("token not found")
+
+	// ErrTokenRevoked is the error returned when a token's record in the cache has been marked as revoked.
+	ErrTokenRevoked = errors.New("token revoked")
 )
 
 // RequestValidator is a function that validates a request to see if it's valid for receiving a JWT.
@@ -48,16 +56,21 @@ type TokenBlockedFunc func(r *http.Request, err error, statusCode int)
 type Service struct {
 	requestValidators []RequestValidator
 	ttl               time.Duration
+	refreshTTL        time.Duration
+	keyRetention      time.Duration
 	enforce           bool
 	tz                *time.Location
 	cache             *redis.Client
-	privateKey        *rsa.PrivateKey
-	publicKey         *rsa.PublicKey
+	keys              *keySet
+	method            jwt.SigningMethod
 	abortRequest      EnforceFunc
 	continueRequest   EnforceFunc
 	tokenBlocked      TokenBlockedFunc
 	keyPrefix         string
 	issuer            string
+	audience          string
+
+	requireVerifiedEmail bool
 }
 
 // Config holds all of the configuration needed to create an authentication service
@@ -65,12 +78,37 @@ type Config struct {
 	// Issuer is the name of the issuer of the token
 	Issuer string `envconfig:"AUTH_ISSUER" default:"youtube-project"`
 
+	// Audience is the intended recipient of the token. Tokens for any other audience are rejected.
+	Audience string `envconfig:"AUTH_AUDIENCE" default:"youtube-project"`
+
+	// TTL is how long an access token is valid for.
+	TTL time.Duration `envconfig:"AUTH_TTL" default:"2h"`
+
+	// RefreshTTL is how long a refresh token is valid for if it isn't used.
+	RefreshTTL time.Duration `envconfig:"AUTH_REFRESH_TTL" default:"720h"`
+
 	// A valid *time.Location used for logging (all timestamps should be UTC internally)
 	TZ *time.Location
 
-	// A PEM-encoded RSA private key
+	// Algorithm is the JWS algorithm tokens are signed with: RS256, RS384, RS512, PS256, PS384, PS512,
+	// ES256, ES384, ES512 or EdDSA. PrivateKey must be a key of the matching type.
+	Algorithm string `envconfig:"AUTH_ALGORITHM" default:"RS512"`
+
+	// A PEM-encoded RSA, ECDSA or Ed25519 private key
 	PrivateKey string
 
+	// PEM-encoded keys (private or public) that are no longer used for signing,
+	// but are still accepted when verifying tokens.
+	RetiringKeys []string
+
+	// RetiringKeyFiles are the paths of the files RetiringKeys are loaded from.
+	RetiringKeyFiles []string `envconfig:"AUTH_RETIRING_KEY_FILES"`
+
+	// KeyRetention is how long a key that has been rotated out is still accepted for verification.
+	// It has to be at least as long as the longest lived token the service signs, which includes
+	// email verification tokens. It is never shorter than TTL.
+	KeyRetention time.Duration `envconfig:"AUTH_KEY_RETENTION" default:"24h"`
+
 	// Enforce should be true if the auth service will actually reject requests that are invalid/unautenticated.
 	// If false, these requests will be logged and passed through.
 	Enforce bool
@@ -79,6 +117,24 @@ type Config struct {
 	// If any of these functions return an error  that != ErrNotUsed, the request shouldn't be considered valid.
 	RequestValidators []RequestValidator
 
+	// APIKeys are the keys accepted by the APIKeyValidator in the X-API-Key header.
+	APIKeys []string `envconfig:"AUTH_API_KEYS"`
+
+	// HMACKeys maps key IDs to the shared secrets accepted by the HMACValidator.
+	HMACKeys map[string]string `envconfig:"AUTH_HMAC_KEYS"`
+
+	// AllowedCIDRs are the client IP ranges accepted by the CIDRValidator.
+	AllowedCIDRs []string `envconfig:"AUTH_ALLOWED_CIDRS"`
+
+	// ClientCertSubjects are the client certificate subjects accepted by the ClientCertValidator.
+	ClientCertSubjects []string `envconfig:"AUTH_CLIENT_CERT_SUBJECTS"`
+
+	// ClientCAFile is the path of the PEM-encoded CAs client certificates are verified against.
+	ClientCAFile string `envconfig:"AUTH_CLIENT_CA_FILE"`
+
+	// RequireVerifiedEmail should be true if users have to verify their email before they can log in.
+	RequireVerifiedEmail bool `envconfig:"AUTH_REQUIRE_VERIFIED_EMAIL" default:"false"`
+
 	// AbortRequest is the function that's invoked if the request is unauthorized and therefore about to be aborted.
 	// AbortRequest is expected to send a responose on the ResponseWriter.
 	AbortRequest EnforceFunc
@@ -93,32 +149,67 @@ type Config struct {
 }
 
 // New returns a new Service with the provided configuration.
-// It will validate the private key and panic if it does not pass.
+// It will validate the algorithm, private key and retiring keys and panic if they do not pass.
 func New(c Config) *Service {
-	key := mustParseRSAPrivateKeyFromPEM(c.PrivateKey)
+	if c.Algorithm == "" {
+		c.Algorithm = jwt.SigningMethodRS512.Alg()
+	}
+
+	method, err := signingMethod(c.Algorithm)
+	if err != nil {
+		panic(err)
+	}
+
+	key := mustParseSigningKeyFromPEM(c.PrivateKey, method)
+
+	retiring := []crypto.PublicKey{}
+	for _, k := range c.RetiringKeys {
+		pub, err := parsePublicKeyFromPEM(k)
+		if err != nil {
+			panic(err)
+		}
+		retiring = append(retiring, pub)
+	}
 
 	if c.TZ == nil {
 		c.TZ = time.Local
 	}
 
+	if c.TTL == 0 {
+		c.TTL = 2 * time.Hour
+	}
+
+	if c.RefreshTTL == 0 {
+		c.RefreshTTL = 30 * 24 * time.Hour
+	}
+
+	if c.KeyRetention < c.TTL {
+		c.KeyRetention = c.TTL
+	}
+
 	return &Service{
 		requestValidators: c.RequestValidators,
-		ttl:               2 * time.Hour,
+		ttl:               c.TTL,
+		refreshTTL:        c.RefreshTTL,
+		keyRetention:      c.KeyRetention,
 		enforce:           c.Enforce,
 		tz:                c.TZ,
 		cache:             c.Cache,
-		privateKey:        key,
-		publicKey:         &key.PublicKey,
+		keys:              newKeySet(newSigningKey(method, key), retiring),
+		method:            method,
 		abortRequest:      c.AbortRequest,
 		continueRequest:   c.ContinueRequest,
 		tokenBlocked:      c.TokenBlocked,
 		keyPrefix:         "auth:",
 		issuer:            c.Issuer,
+		audience:          c.Audience,
+
+		requireVerifiedEmail: c.RequireVerifiedEmail,
 	}
 }
 
-func mustParseRSAPrivateKeyFromPEM(privateKey string) *rsa.PrivateKey {
-	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(privateKey))
+func mustParseSigningKeyFromPEM(privateKey string, method jwt.SigningMethod) crypto.PrivateKey {
+	key, err := parseSigningKeyFromPEM(privateKey, method)
 	if err != nil {
 		panic(err)
 	}
@@ -126,37 +217,71 @@ func mustParseRSAPrivateKeyFromPEM(privateKey string) *rsa.PrivateKey {
 	return key
 }
 
-// NewSignedToken creates a new JWT, persists it to Redis and returns the signed token.
-// It can return an error if there is an issue signing the token with th egiven RSA private key or saving to the cache.
-func (s *Service) NewSignedToken() (string, error) {
+// NewSignedToken creates a new JWT with the provided claims, persists it to Redis and returns the signed token.
+// The registered claims (exp, iat, iss, aud and jti) are filled in by the service. The subject may be empty
+// for tokens that aren't tied to a user.
+// It can return an error if there is an issue signing the token with th egiven private key or saving to the cache.
+func (s *Service) NewSignedToken(c Claims) (string, error) {
 	now := time.Now().UTC()
 	expiresAt := now.Add(s.ttl)
 
-	claims := &jwt.StandardClaims{
-		ExpiresAt: expiresAt.Unix(),
-		Issuer:    s.issuer,
+	jti, err := newTokenID()
+	if err != nil {
+		return "", errors.Wrap(err, "new token id")
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodRS512, claims)
+	claims := &c
+	claims.ExpiresAt = expiresAt.Unix()
+	claims.IssuedAt = now.Unix()
+	claims.Issuer = s.issuer
+	claims.Audience = s.audience
+	claims.Id = jti
+	subject := claims.Subject
+
+	signer := s.keys.signer()
 
-	ss, err := token.SignedString(s.privateKey)
+	token := jwt.NewWithClaims(signer.method, claims)
+	token.Header["kid"] = signer.id
+
+	ss, err := token.SignedString(signer.private)
 	if err != nil {
 		return "", errors.Wrap(err, "signed string")
 	}
 
-	key := fmt.Sprintf("token:%s", ss)
 	val := expiresAt.Unix()
 	exp := expiresAt.Sub(now)
 
-	err = s.cache.Set(key, val, exp).Err()
+	err = s.cache.Set(tokenCacheKey(ss), val, exp).Err()
 	if err != nil {
 		return "", errors.Wrap(err, "error persisting token to cache")
 	}
 
+	if subject != "" {
+		err = s.indexToken(subject, ss, exp)
+		if err != nil {
+			return "", errors.Wrap(err, "index token")
+		}
+	}
+
 	return ss, nil
 }
 
-// IssueTokenHandler is the http.Handler that can issue JWTs signed with the provided RSA Key
+// Issuer returns the name the service issues tokens as.
+func (s *Service) Issuer() string {
+	return s.issuer
+}
+
+// TTL returns how long the access tokens issued by the service are valid for.
+func (s *Service) TTL() time.Duration {
+	return s.ttl
+}
+
+// RequireVerifiedEmail returns true if tokens should only be issued to users who have verified their email.
+func (s *Service) RequireVerifiedEmail() bool {
+	return s.requireVerifiedEmail
+}
+
+// IssueTokenHandler is the http.Handler that can issue JWTs signed with the provided private key
 func (s *Service) IssueTokenHandler(w http.ResponseWriter, r *http.Request) {
 	if !s.validRequest(r) {
 		s.tokenBlocked(r, ErrNotAuthorized, http.StatusUnauthorized)
@@ -166,7 +291,7 @@ func (s *Service) IssueTokenHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	ss, err := s.NewSignedToken()
+	ss, err := s.NewSignedToken(Claims{})
 	if err != nil {
 		s.tokenBlocked(r, ErrGenerateToken, http.StatusInternalServerError)
 		if s.enforce {
@@ -187,8 +312,8 @@ func (s *Service) IssueTokenHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // validRequest return true if:
-// 		- there are 0 RequestValidators
-//		-at least one RequestValidators returns nil error and the others return nil error or ErrNotUsed
+//   - there are 0 RequestValidators
+//     -at least one RequestValidators returns nil error and the others return nil error or ErrNotUsed
 func (s *Service) validRequest(r *http.Request) bool {
 	valid := len(s.requestValidators) == 0
 
@@ -248,38 +373,73 @@ func (s *Service) RequireValidToken(next http.Handler) http.Handler {
 			}
 		}
 
-		// Parse the token and verify the claims.
-		// If the token can't be parsed or the claims aren't valid, the token is unauthorized.
-
-		claims := &jwt.StandardClaims{}
-		jwtParser := &jwt.Parser{
-			// We want different error messages for a malformed JWT vs on that's expired,
-			// so we will validate the claims separately.
-			SkipClaimsValidation: true,
-		}
-
-		_, err := jwtParser.ParseWithClaims(rawToken, claims, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
-				return nil, errors.Errorf("unexpected signing method: %T", token.Header["alg"])
-			}
-			return s.publicKey, nil
-		})
-		if err != nil {
-			enforce(w, r, errors.Wrap(err, "parse jwt"), http.StatusUnauthorized)
+		claims, err := s.ValidateToken(rawToken)
+		switch errors.Cause(err) {
+		case nil:
+		case ErrInvalidToken, ErrTokenNotFound, ErrTokenRevoked:
+			enforce(w, r, err, http.StatusUnauthorized)
 			return
-		}
-
-		err = claims.Valid()
-		if err != nil {
-			enforce(w, r, errors.Wrap(err, "invalid claims"), http.StatusUnauthorized)
+		default:
+			enforce(w, r, errors.Wrap(err, "validate token"), http.StatusInternalServerError)
 			return
 		}
 
 		log.WithField("expiresAt", time.Unix(claims.ExpiresAt, 0).Local()).Info("token authenticated")
 
-		// Put the token in the request context to be used by later middlewares.
-		r = r.WithContext(context.WithValue(r.Context(), tokenKey, rawToken))
+		// Keep track of where the session was last used. Failing to do so shouldn't fail the request.
+		if claims.SessionID != "" {
+			err = s.touchSession(claims.SessionID, DeviceFromRequest(r))
+			if err != nil {
+				log.WithError(err).Info("touch session")
+			}
+		}
+
+		// Put the token and its claims in the request context to be used by later middlewares.
+		ctx := context.WithValue(r.Context(), tokenKey, rawToken)
+		ctx = context.WithValue(ctx, claimsKey, claims)
+		r = r.WithContext(ctx)
 
 		next.ServeHTTP(w, r)
 	})
 }
+
+// ValidateToken parses the token, verifies its signature and claims and makes sure its record in the cache hasn't been revoked.
+// It returns ErrInvalidToken if the token can't be parsed or its claims aren't valid, and ErrTokenNotFound or
+// ErrTokenRevoked if its record is missing or revoked. Any other error means the token couldn't be checked.
+func (s *Service) ValidateToken(rawToken string) (Claims, error) {
+	// Parse the token and verify the claims.
+	// If the token can't be parsed or the claims aren't valid, the token is unauthorized.
+
+	claims := &Claims{}
+	jwtParser := &jwt.Parser{
+		// We want different error messages for a malformed JWT vs on that's expired,
+		// so we will validate the claims separately.
+		SkipClaimsValidation: true,
+	}
+
+	_, err := jwtParser.ParseWithClaims(rawToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return s.keys.verifier(kid, token.Method.Alg())
+	})
+	if err != nil {
+		return Claims{}, errors.Wrapf(ErrInvalidToken, "parse jwt: %s", err)
+	}
+
+	err = claims.Valid()
+	if err != nil {
+		return Claims{}, errors.Wrapf(ErrInvalidToken, "invalid claims: %s", err)
+	}
+
+	if s.audience != "" && !claims.VerifyAudience(s.audience, true) {
+		return Claims{}, errors.Wrap(ErrInvalidToken, "invalid audience")
+	}
+
+	// A token is only as good as its record in the cache. If the record is gone or
+	// has been revoked, the token is no longer valid even if it hasn't expired.
+	err = s.checkTokenRecord(rawToken)
+	if err != nil {
+		return Claims{}, errors.Wrap(err, "check token record")
+	}
+
+	return *claims, nil
+}
@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+)
+
+// newTestService returns a Service that signs with a new EdDSA key and keeps its records in a miniredis.
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+
+	return New(Config{
+		Algorithm:  "EdDSA",
+		PrivateKey: newTestKey(t),
+		Cache:      redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()}),
+	})
+}
+
+// newTestKey returns a new PEM-encoded Ed25519 private key.
+func newTestKey(t *testing.T) string {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
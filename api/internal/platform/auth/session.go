@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+// ErrSessionNotFound is the error returned when a session doesn't exist or doesn't belong to the subject.
+var ErrSessionNotFound = errors.New("session not found")
+
+// Device describes the client a session is being used from.
+type Device struct {
+	UserAgent string
+	IP        string
+}
+
+// DeviceFromRequest returns the Device the request was made from.
+func DeviceFromRequest(r *http.Request) Device {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return Device{
+		UserAgent: r.UserAgent(),
+		IP:        host,
+	}
+}
+
+// Session is a login that can still be used to get new tokens. Each session is a refresh token family,
+// so it lasts from the login until its refresh token expires or is revoked.
+type Session struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	IssuedAt   time.Time `json:"issued_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+}
+
+// familySessionCacheKey returns the key of the hash describing the session a family belongs to.
+func (s *Service) familySessionCacheKey(family string) string {
+	return fmt.Sprintf("%sfamily:%s:session", s.keyPrefix, family)
+}
+
+// touchSessionScript updates a session's hash only if it still exists.
+// Checking and updating happen in one step so a session revoked in between isn't brought back.
+var touchSessionScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+redis.call('HMSET', KEYS[1], 'ip', ARGV[1], 'last_seen', ARGV[2])
+return 1
+`)
+
+// touchSession records that the session was just used from the device.
+// Sessions that have been revoked or have expired are not brought back.
+func (s *Service) touchSession(family string, d Device) error {
+	err := touchSessionScript.Run(s.cache, []string{s.familySessionCacheKey(family)}, d.IP, time.Now().UTC().Unix()).Err()
+	if err != nil {
+		return errors.Wrap(err, "touch session")
+	}
+
+	return nil
+}
+
+// Sessions returns every active session of the subject, most recently used first.
+func (s *Service) Sessions(subject string) ([]Session, error) {
+	familiesKey := s.subjectFamiliesCacheKey(subject)
+
+	families, err := s.cache.SMembers(familiesKey).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "get subject families")
+	}
+
+	sessions := []Session{}
+	for _, f := range families {
+		vals, err := s.cache.HGetAll(s.familySessionCacheKey(f)).Result()
+		if err != nil {
+			return nil, errors.Wrap(err, "get session")
+		}
+
+		// The session has expired or been revoked, so it no longer belongs in the index.
+		if len(vals) == 0 {
+			err = s.cache.SRem(familiesKey, f).Err()
+			if err != nil {
+				return nil, errors.Wrap(err, "remove session")
+			}
+			continue
+		}
+
+		issuedAt, _ := strconv.ParseInt(vals["issued_at"], 10, 64)
+		lastSeen, _ := strconv.ParseInt(vals["last_seen"], 10, 64)
+
+		sessions = append(sessions, Session{
+			ID:         f,
+			UserAgent:  vals["user_agent"],
+			IP:         vals["ip"],
+			IssuedAt:   time.Unix(issuedAt, 0).UTC(),
+			LastSeenAt: time.Unix(lastSeen, 0).UTC(),
+		})
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+
+	return sessions, nil
+}
+
+// RevokeSession revokes one of the subject's sessions, including every token issued in it.
+// It returns ErrSessionNotFound if the session doesn't belong to the subject.
+func (s *Service) RevokeSession(subject, id string) error {
+	familiesKey := s.subjectFamiliesCacheKey(subject)
+
+	ok, err := s.cache.SIsMember(familiesKey, id).Result()
+	if err != nil {
+		return errors.Wrap(err, "get subject families")
+	}
+
+	if !ok {
+		return ErrSessionNotFound
+	}
+
+	err = s.revokeFamily(id)
+	if err != nil {
+		return errors.Wrap(err, "revoke family")
+	}
+
+	err = s.cache.SRem(familiesKey, id).Err()
+	if err != nil {
+		return errors.Wrap(err, "remove session")
+	}
+
+	return nil
+}
+
+// RevokeOtherSessions revokes every one of the subject's sessions except the one being kept.
+func (s *Service) RevokeOtherSessions(subject, keep string) error {
+	families, err := s.cache.SMembers(s.subjectFamiliesCacheKey(subject)).Result()
+	if err != nil {
+		return errors.Wrap(err, "get subject families")
+	}
+
+	for _, f := range families {
+		if f == keep {
+			continue
+		}
+
+		err = s.RevokeSession(subject, f)
+		if err != nil && err != ErrSessionNotFound {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,232 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+/*
+	This file provides RequestValidators that can be passed to Config.RequestValidators.
+
+	Each validator only looks at its own credential. If the credential isn't present on
+	the request, the validator returns ErrNotUsed so it can be combined with the others:
+	a request is valid if at least one validator accepts it and none of them reject it.
+*/
+
+// Headers read by the HMACValidator.
+const (
+	HeaderSignatureKey       = "X-Signature-Key"
+	HeaderSignatureTimestamp = "X-Signature-Timestamp"
+	HeaderSignatureNonce     = "X-Signature-Nonce"
+	HeaderSignature          = "X-Signature"
+)
+
+// APIKeyValidator returns a RequestValidator that accepts requests presenting one of the provided keys in the header.
+func APIKeyValidator(header string, keys []string) RequestValidator {
+	// Only hashes of the keys are compared so every comparison takes the same amount of time.
+	hashes := make([][32]byte, 0, len(keys))
+	for _, k := range keys {
+		hashes = append(hashes, sha256.Sum256([]byte(k)))
+	}
+
+	return func(r *http.Request) error {
+		key := r.Header.Get(header)
+		if key == "" {
+			return ErrNotUsed
+		}
+
+		sum := sha256.Sum256([]byte(key))
+
+		valid := 0
+		for _, h := range hashes {
+			valid |= subtle.ConstantTimeCompare(sum[:], h[:])
+		}
+
+		if valid != 1 {
+			return errors.Wrap(ErrNotAuthorized, "invalid api key")
+		}
+
+		return nil
+	}
+}
+
+// HMACConfig holds the configuration for an HMACValidator.
+type HMACConfig struct {
+	// Keys maps each key ID to its shared secret.
+	Keys map[string]string
+
+	// MaxSkew is how far the request's timestamp may be from the current time.
+	MaxSkew time.Duration
+
+	// Cache is used to remember the nonces that have been seen so requests can't be replayed.
+	Cache *redis.Client
+
+	// KeyPrefix is prepended to the cache keys used for nonces.
+	KeyPrefix string
+}
+
+// HMACValidator returns a RequestValidator that accepts requests signed with one of the configured shared secrets.
+//
+// The signature is the hex-encoded HMAC-SHA256 of the following lines joined by "\n":
+//   - the request method
+//   - the request URI (path and query)
+//   - the X-Signature-Timestamp header (unix seconds)
+//   - the X-Signature-Nonce header
+//   - the hex-encoded SHA-256 of the request body
+//
+// Requests whose timestamp is outside MaxSkew, or whose nonce has already been seen, are rejected.
+func HMACValidator(c HMACConfig) RequestValidator {
+	if c.MaxSkew == 0 {
+		c.MaxSkew = 5 * time.Minute
+	}
+
+	if c.KeyPrefix == "" {
+		c.KeyPrefix = "auth:"
+	}
+
+	return func(r *http.Request) error {
+		keyID := r.Header.Get(HeaderSignatureKey)
+		signature := r.Header.Get(HeaderSignature)
+		if keyID == "" && signature == "" {
+			return ErrNotUsed
+		}
+
+		secret, ok := c.Keys[keyID]
+		if !ok {
+			return errors.Wrap(ErrNotAuthorized, "unknown signature key")
+		}
+
+		ts := r.Header.Get(HeaderSignatureTimestamp)
+		unix, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			return errors.Wrap(ErrNotAuthorized, "invalid signature timestamp")
+		}
+
+		skew := time.Since(time.Unix(unix, 0))
+		if skew > c.MaxSkew || skew < -c.MaxSkew {
+			return errors.Wrap(ErrNotAuthorized, "signature timestamp out of range")
+		}
+
+		nonce := r.Header.Get(HeaderSignatureNonce)
+		if nonce == "" {
+			return errors.Wrap(ErrNotAuthorized, "missing signature nonce")
+		}
+
+		body, err := readBody(r)
+		if err != nil {
+			return errors.Wrap(err, "read body")
+		}
+
+		bodySum := sha256.Sum256(body)
+		mac := hmac.New(sha256.New, []byte(secret))
+		fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%s", r.Method, r.URL.RequestURI(), ts, nonce, hex.EncodeToString(bodySum[:]))
+		expected := hex.EncodeToString(mac.Sum(nil))
+
+		if !hmac.Equal([]byte(expected), []byte(signature)) {
+			return errors.Wrap(ErrNotAuthorized, "invalid signature")
+		}
+
+		// The nonce only needs to be remembered for as long as the timestamp would be accepted.
+		key := fmt.Sprintf("%snonce:%s:%s", c.KeyPrefix, keyID, nonce)
+		first, err := c.Cache.SetNX(key, ts, 2*c.MaxSkew).Result()
+		if err != nil {
+			return errors.Wrap(err, "persist nonce")
+		}
+
+		if !first {
+			return errors.Wrap(ErrNotAuthorized, "replayed signature nonce")
+		}
+
+		return nil
+	}
+}
+
+// readBody reads the request body and replaces it so it can be read again further down the chain.
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return []byte{}, nil
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body.Close()
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// CIDRValidator returns a RequestValidator that accepts requests from client IPs within the provided CIDRs.
+// Being on an allowed network is the credential, so requests from every other IP return ErrNotUsed
+// and are left to the other validators.
+// The client IP is taken from the connection, so a proxy in front of the service must be included in the allowlist.
+func CIDRValidator(cidrs []string) (RequestValidator, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse cidr: %s", c)
+		}
+		nets = append(nets, n)
+	}
+
+	return func(r *http.Request) error {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return ErrNotUsed
+		}
+
+		for _, n := range nets {
+			if n.Contains(ip) {
+				return nil
+			}
+		}
+
+		return ErrNotUsed
+	}, nil
+}
+
+// ClientCertValidator returns a RequestValidator that accepts requests presenting a verified TLS client
+// certificate whose subject common name or full distinguished name is one of the provided subjects.
+// The server's tls.Config must request and verify client certificates for this validator to be used.
+func ClientCertValidator(subjects []string) RequestValidator {
+	allowed := map[string]bool{}
+	for _, s := range subjects {
+		allowed[s] = true
+	}
+
+	return func(r *http.Request) error {
+		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+			return ErrNotUsed
+		}
+
+		if len(r.TLS.VerifiedChains) == 0 {
+			return errors.Wrap(ErrNotAuthorized, "unverified client certificate")
+		}
+
+		subject := r.TLS.VerifiedChains[0][0].Subject
+		if !allowed[subject.CommonName] && !allowed[subject.String()] {
+			return errors.Wrapf(ErrNotAuthorized, "client certificate subject not allowed: %s", subject)
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// emailVerificationAudience is the audience of email verification tokens.
+// It keeps them from being accepted anywhere an access token is expected.
+const emailVerificationAudience = "email-verification"
+
+// ErrInvalidVerificationToken is the error returned when an email verification token can't be parsed, has expired or wasn't issued for email verification.
+var ErrInvalidVerificationToken = errors.New("invalid verification token")
+
+// NewEmailVerificationToken returns a signed token that proves the subject received an email sent to the address.
+// Verification tokens aren't persisted; they are only as good as their signature and expiry.
+func (s *Service) NewEmailVerificationToken(subject, email string, ttl time.Duration) (string, error) {
+	now := time.Now().UTC()
+
+	jti, err := newTokenID()
+	if err != nil {
+		return "", errors.Wrap(err, "new token id")
+	}
+
+	claims := &Claims{
+		Email: email,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   subject,
+			ExpiresAt: now.Add(ttl).Unix(),
+			IssuedAt:  now.Unix(),
+			Issuer:    s.issuer,
+			Audience:  emailVerificationAudience,
+			Id:        jti,
+		},
+	}
+
+	signer := s.keys.signer()
+
+	token := jwt.NewWithClaims(signer.method, claims)
+	token.Header["kid"] = signer.id
+
+	ss, err := token.SignedString(signer.private)
+	if err != nil {
+		return "", errors.Wrap(err, "signed string")
+	}
+
+	return ss, nil
+}
+
+// ParseEmailVerificationToken verifies a token created by NewEmailVerificationToken and returns its claims.
+// The caller still has to check that the email in the claims is the subject's current email.
+func (s *Service) ParseEmailVerificationToken(rawToken string) (Claims, error) {
+	claims := &Claims{}
+
+	_, err := jwt.ParseWithClaims(rawToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return s.keys.verifier(kid, token.Method.Alg())
+	})
+	if err != nil {
+		return Claims{}, errors.Wrapf(ErrInvalidVerificationToken, "parse jwt: %s", err)
+	}
+
+	if !claims.VerifyAudience(emailVerificationAudience, true) {
+		return Claims{}, errors.Wrap(ErrInvalidVerificationToken, "invalid audience")
+	}
+
+	if claims.Subject == "" || claims.Email == "" {
+		return Claims{}, errors.Wrap(ErrInvalidVerificationToken, "missing subject or email")
+	}
+
+	return *claims, nil
+}
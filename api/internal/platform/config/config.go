@@ -2,12 +2,18 @@ package config
 
 import (
 	"os"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/jongschneider/youtube-project/api/internal/platform/auth"
 	"github.com/jongschneider/youtube-project/api/internal/platform/cache"
 	"github.com/jongschneider/youtube-project/api/internal/platform/database"
+	"github.com/jongschneider/youtube-project/api/internal/platform/encryption"
 	"github.com/jongschneider/youtube-project/api/internal/platform/env"
+	"github.com/jongschneider/youtube-project/api/internal/platform/lockout"
+	"github.com/jongschneider/youtube-project/api/internal/platform/mail"
+	"github.com/jongschneider/youtube-project/api/internal/platform/migrate"
+	"github.com/jongschneider/youtube-project/api/internal/platform/password"
 	"github.com/kelseyhightower/envconfig"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -16,12 +22,35 @@ import (
 
 // Base holds the shared config used by each binary in this repo
 type Base struct {
-	AppConfig   env.App
-	DBConfig    database.Config
-	CacheConfig cache.Config
-	AuthConfig  auth.Config
-	Port        int  `envconfig:"PORT" required:"true" default:"3000"`
-	Debug       bool `envconfig:"DEBUG" default:"false"`
+	AppConfig      env.App
+	DBConfig       database.Config
+	CacheConfig    cache.Config
+	AuthConfig     auth.Config
+	HashConfig     encryption.Config
+	PasswordConfig password.Config
+	LockoutConfig  lockout.Config
+	MailConfig     mail.Config
+	MigrateConfig  migrate.Config
+	Port           int  `envconfig:"PORT" required:"true" default:"3000"`
+	Debug          bool `envconfig:"DEBUG" default:"false"`
+
+	// PasswordResetTTL is how long a password reset link is valid for.
+	PasswordResetTTL time.Duration `envconfig:"PASSWORD_RESET_TTL" default:"1h"`
+
+	// EmailVerificationTTL is how long an email verification link is valid for.
+	EmailVerificationTTL time.Duration `envconfig:"EMAIL_VERIFICATION_TTL" default:"24h"`
+
+	// UserRetention is how long deleted users can be restored before they are purged.
+	UserRetention time.Duration `envconfig:"USER_RETENTION" default:"720h"`
+
+	// UserPurgeInterval is how often users past their retention are purged.
+	UserPurgeInterval time.Duration `envconfig:"USER_PURGE_INTERVAL" default:"1h"`
+
+	// RecoveryCodeKey is the secret MFA recovery codes are hashed with. It must be at least 32 characters.
+	RecoveryCodeKey string `envconfig:"MFA_RECOVERY_CODE_KEY" required:"true"`
+
+	// RequireIfMatch should be true if writes to a user must send the ETag they are based on.
+	RequireIfMatch bool `envconfig:"USER_REQUIRE_IF_MATCH" default:"false"`
 }
 
 // configurable is an internal interface to enforce this config as an embedded struct if another program wants to modify it.
@@ -42,13 +71,28 @@ func (c Base) LogFields() logrus.Fields {
 	}
 
 	if c.Debug {
-		fields["db_user"] = c.DBConfig.User
-		fields["db_pass"] = c.DBConfig.Password
-		fields["db_host"] = c.DBConfig.Host
-		fields["db_port"] = c.DBConfig.Port
-		fields["db_name"] = c.DBConfig.DBName
-		fields["db_tls"] = c.DBConfig.TLS
-		fields["db_multistatements"] = c.DBConfig.MultiStatements
+		fields["db_dialect"] = c.DBConfig.Dialect
+		fields["db_query_timeout"] = c.DBConfig.QueryTimeout
+		fields["db_max_open_conns"] = c.DBConfig.Pool.MaxOpenConns
+		fields["db_max_idle_conns"] = c.DBConfig.Pool.MaxIdleConns
+		fields["db_conn_max_lifetime"] = c.DBConfig.Pool.ConnMaxLifetime
+		fields["db_conn_max_idle_time"] = c.DBConfig.Pool.ConnMaxIdleTime
+		if c.DBConfig.Dialect == database.Postgres {
+			fields["db_user"] = c.DBConfig.Postgres.User
+			fields["db_pass"] = c.DBConfig.Postgres.Password
+			fields["db_host"] = c.DBConfig.Postgres.Host
+			fields["db_port"] = c.DBConfig.Postgres.Port
+			fields["db_name"] = c.DBConfig.Postgres.DBName
+			fields["db_sslmode"] = c.DBConfig.Postgres.SSLMode
+		} else {
+			fields["db_user"] = c.DBConfig.User
+			fields["db_pass"] = c.DBConfig.Password
+			fields["db_host"] = c.DBConfig.Host
+			fields["db_port"] = c.DBConfig.Port
+			fields["db_name"] = c.DBConfig.DBName
+			fields["db_tls"] = c.DBConfig.TLS
+			fields["db_multistatements"] = c.DBConfig.MultiStatements
+		}
 
 		fields["redis_host"] = c.CacheConfig.Host
 		fields["redis_port"] = c.CacheConfig.Port
@@ -2,18 +2,44 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"expvar"
 	"fmt"
+	"net/url"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql" // provides the mysql driver for sqlx
+	"github.com/go-sql-driver/mysql" // provides the mysql driver for sqlx
 	"github.com/jimmysawczuk/try"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq" // provides the postgres driver for sqlx
+	"github.com/pkg/errors"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
 )
 
-var driverName = "mysql"
+var (
+	// ErrTimeout is the error returned when a query takes longer than its timeout.
+	ErrTimeout = errors.New("query timed out")
+
+	// ErrCanceled is the error returned when a query is canceled before it finishes, like when the client that asked for it goes away.
+	ErrCanceled = errors.New("query canceled")
+
+	// ErrDuplicate is the error returned when a write would break a unique index.
+	ErrDuplicate = errors.New("duplicate value")
+)
+
+// Dialects of SQL the database package can connect to.
+const (
+	MySQL    = "mysql"
+	Postgres = "postgres"
+	SQLite   = "sqlite"
+)
 
 // Config holds all of the configuration for a database connection
 type Config struct {
+	// Dialect selects the database to connect to: mysql or postgres.
+	Dialect string `envconfig:"DB_DIALECT" default:"mysql"`
+
 	User            string `envconfig:"MYSQL_USER" required:"true" default:"root"`
 	Password        string `envconfig:"MYSQL_PASSWORD" default:""`
 	Host            string `envconfig:"MYSQL_HOST" required:"true" default:"localhost"`
@@ -21,16 +47,78 @@ type Config struct {
 	DBName          string `envconfig:"MYSQL_DBNAME" required:"true" default:"example"`
 	TLS             bool   `envconfig:"MYSQL_TLS" required:"true" default:"false"`
 	MultiStatements bool   `envconfig:"MYSQL_MULTISTATEMENTS" required:"true" default:"true"`
+
+	Postgres PostgresConfig
+
+	// QueryTimeout is how long a query can take before it is given up on. 0 means queries never time out.
+	QueryTimeout time.Duration `envconfig:"DB_QUERY_TIMEOUT" default:"5s"`
+
+	Pool PoolConfig
 }
 
-// DB represents a db connection
+// PoolConfig holds the configuration for the pool of connections to the db.
+type PoolConfig struct {
+	// MaxOpenConns is the most connections that can be open at once, including those in use. 0 means no limit.
+	MaxOpenConns int `envconfig:"DB_MAX_OPEN_CONNS" default:"25"`
+
+	// MaxIdleConns is the most connections kept open while they aren't in use. It can't be more than MaxOpenConns.
+	MaxIdleConns int `envconfig:"DB_MAX_IDLE_CONNS" default:"25"`
+
+	// ConnMaxLifetime is how long a connection can be reused for. It should be shorter than the db's own timeouts,
+	// so connections are closed by the api before the db closes them. 0 means connections are reused forever.
+	ConnMaxLifetime time.Duration `envconfig:"DB_CONN_MAX_LIFETIME" default:"3m"`
+
+	// ConnMaxIdleTime is how long a connection can stay idle before it is closed. 0 means idle connections aren't closed.
+	ConnMaxIdleTime time.Duration `envconfig:"DB_CONN_MAX_IDLE_TIME" default:"1m"`
+}
+
+// PoolStats are statistics about the pool of connections to the db.
+type PoolStats struct {
+	MaxOpenConns int `json:"max_open_conns"`
+
+	OpenConns int `json:"open_conns"`
+	InUse     int `json:"in_use"`
+	Idle      int `json:"idle"`
+
+	// WaitCount is how many times a query had to wait for a connection, and WaitDurationSeconds is how long they waited in total.
+	WaitCount           int64   `json:"wait_count"`
+	WaitDurationSeconds float64 `json:"wait_duration_seconds"`
+
+	// How many connections were closed because of MaxIdleConns, ConnMaxIdleTime and ConnMaxLifetime.
+	MaxIdleClosed     int64 `json:"max_idle_closed"`
+	MaxIdleTimeClosed int64 `json:"max_idle_time_closed"`
+	MaxLifetimeClosed int64 `json:"max_lifetime_closed"`
+}
+
+// PostgresConfig holds the configuration for a connection to postgres.
+type PostgresConfig struct {
+	User     string `envconfig:"POSTGRES_USER" default:"postgres"`
+	Password string `envconfig:"POSTGRES_PASSWORD" default:""`
+	Host     string `envconfig:"POSTGRES_HOST" default:"localhost"`
+	Port     int    `envconfig:"POSTGRES_PORT" default:"5432"`
+	DBName   string `envconfig:"POSTGRES_DBNAME" default:"example"`
+
+	// SSLMode is one of disable, require, verify-ca or verify-full.
+	SSLMode string `envconfig:"POSTGRES_SSLMODE" default:"disable"`
+
+	// SSLRootCert is the path to the CA certificate used by the verify-ca and verify-full modes.
+	SSLRootCert string `envconfig:"POSTGRES_SSLROOTCERT" default:""`
+}
+
+// DB represents a db connection.
+// Queries are written with ? placeholders, which are rebound to the placeholders of the dialect.
 type DB struct {
 	*sqlx.DB
+	dialect      string
+	queryTimeout time.Duration
 }
 
 // New returns a new db connection
 func New(cfg Config) *DB {
-	connectionString := getConnectionString(cfg)
+	dialect, connectionString, err := getConnectionString(cfg)
+	if err != nil {
+		panic(err)
+	}
 
 	// We have to use the try.Try package to connect to the db because when we are setting up the
 	// services to run in our dev environment, the api is ready before the db. When the api tries
@@ -41,9 +129,8 @@ func New(cfg Config) *DB {
 	// doing this until the timeout has elapsed - in this case 160 seconds.
 	// This gives our db time to set up and the api a chance to make a connection.
 	var db *sqlx.DB
-	var err error
 	if terr := try.Try(func() error {
-		db, err = sqlx.Open(driverName, connectionString)
+		db, err = sqlx.Open(dialect, connectionString)
 		if err != nil {
 			return err
 		}
@@ -60,15 +147,245 @@ func New(cfg Config) *DB {
 		panic(terr)
 	}
 
-	return &DB{db}
+	configurePool(db, cfg.Pool)
+
+	return &DB{DB: db, dialect: dialect, queryTimeout: cfg.QueryTimeout}
+}
+
+// configurePool applies the pool configuration to the db.
+func configurePool(db *sqlx.DB, cfg PoolConfig) {
+	if cfg.MaxOpenConns > 0 && cfg.MaxIdleConns > cfg.MaxOpenConns {
+		cfg.MaxIdleConns = cfg.MaxOpenConns
+	}
+
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
 }
 
-func getConnectionString(cfg Config) string {
+// Open returns a connection to the database at dsn. Unlike New it doesn't wait for the database to come up,
+// so it is meant for databases that are always available, like SQLite files. The driver of the dialect must be imported.
+// Queries are given up on after queryTimeout, like with Config.QueryTimeout. 0 means they never time out.
+func Open(dialect, dsn string, queryTimeout time.Duration) (*DB, error) {
+	db, err := sqlx.Open(dialect, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = pingDB(ctx, db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &DB{DB: db, dialect: dialect, queryTimeout: queryTimeout}, nil
+}
+
+// PoolStats returns statistics about the pool of connections to the db.
+func (db *DB) PoolStats() PoolStats {
+	s := db.Stats()
+
+	return PoolStats{
+		MaxOpenConns:        s.MaxOpenConnections,
+		OpenConns:           s.OpenConnections,
+		InUse:               s.InUse,
+		Idle:                s.Idle,
+		WaitCount:           s.WaitCount,
+		WaitDurationSeconds: s.WaitDuration.Seconds(),
+		MaxIdleClosed:       s.MaxIdleClosed,
+		MaxIdleTimeClosed:   s.MaxIdleTimeClosed,
+		MaxLifetimeClosed:   s.MaxLifetimeClosed,
+	}
+}
+
+// PublishStats publishes the pool statistics as an expvar with the name, so they are reported with the other metrics.
+// It panics if the name is already used.
+func (db *DB) PublishStats(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return db.PoolStats()
+	}))
+}
+
+// Dialect returns the dialect of SQL the db speaks.
+func (db *DB) Dialect() string {
+	return db.dialect
+}
+
+// Select runs the query with the default query timeout.
+func (db *DB) Select(dest interface{}, query string, args ...interface{}) error {
+	return db.SelectContext(context.Background(), dest, query, args...)
+}
+
+// Get runs the query with the default query timeout.
+func (db *DB) Get(dest interface{}, query string, args ...interface{}) error {
+	return db.GetContext(context.Background(), dest, query, args...)
+}
+
+// Exec runs the query with the default query timeout.
+func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.ExecContext(context.Background(), query, args...)
+}
+
+// SelectContext runs the query, rebound for the dialect, and scans each row into dest.
+// It gives up when ctx is done or the default query timeout elapses, whichever is first.
+func (db *DB) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	ctx, cancel := withTimeout(ctx, db.queryTimeout)
+	defer cancel()
+
+	return QueryError(ctx, db.DB.SelectContext(ctx, dest, db.Rebind(query), args...))
+}
+
+// GetContext runs the query, rebound for the dialect, and scans the row into dest.
+// It gives up when ctx is done or the default query timeout elapses, whichever is first.
+func (db *DB) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	ctx, cancel := withTimeout(ctx, db.queryTimeout)
+	defer cancel()
+
+	return QueryError(ctx, db.DB.GetContext(ctx, dest, db.Rebind(query), args...))
+}
+
+// ExecContext runs the query, rebound for the dialect.
+// It gives up when ctx is done or the default query timeout elapses, whichever is first.
+func (db *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, cancel := withTimeout(ctx, db.queryTimeout)
+	defer cancel()
+
+	res, err := db.DB.ExecContext(ctx, db.Rebind(query), args...)
+	return res, QueryError(ctx, err)
+}
+
+// Beginx begins a transaction whose queries are rebound for the dialect.
+func (db *DB) Beginx() (*Tx, error) {
+	return db.BeginTxx(context.Background(), nil)
+}
+
+// BeginTxx begins a transaction whose queries are rebound for the dialect.
+// The transaction is rolled back if ctx is done before it is committed.
+func (db *DB) BeginTxx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	tx, err := db.DB.BeginTxx(ctx, opts)
+	if err != nil {
+		return nil, QueryError(ctx, err)
+	}
+
+	return &Tx{Tx: tx, queryTimeout: db.queryTimeout}, nil
+}
+
+// Tx represents a transaction. Like DB, its queries are written with ? placeholders and have the default query timeout.
+type Tx struct {
+	*sqlx.Tx
+	queryTimeout time.Duration
+}
+
+// Select runs the query with the default query timeout.
+func (tx *Tx) Select(dest interface{}, query string, args ...interface{}) error {
+	return tx.SelectContext(context.Background(), dest, query, args...)
+}
+
+// Get runs the query with the default query timeout.
+func (tx *Tx) Get(dest interface{}, query string, args ...interface{}) error {
+	return tx.GetContext(context.Background(), dest, query, args...)
+}
+
+// Exec runs the query with the default query timeout.
+func (tx *Tx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.ExecContext(context.Background(), query, args...)
+}
+
+// SelectContext runs the query, rebound for the dialect, and scans each row into dest.
+// It gives up when ctx is done or the default query timeout elapses, whichever is first.
+func (tx *Tx) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	ctx, cancel := withTimeout(ctx, tx.queryTimeout)
+	defer cancel()
+
+	return QueryError(ctx, tx.Tx.SelectContext(ctx, dest, tx.Rebind(query), args...))
+}
+
+// GetContext runs the query, rebound for the dialect, and scans the row into dest.
+// It gives up when ctx is done or the default query timeout elapses, whichever is first.
+func (tx *Tx) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	ctx, cancel := withTimeout(ctx, tx.queryTimeout)
+	defer cancel()
+
+	return QueryError(ctx, tx.Tx.GetContext(ctx, dest, tx.Rebind(query), args...))
+}
+
+// ExecContext runs the query, rebound for the dialect.
+// It gives up when ctx is done or the default query timeout elapses, whichever is first.
+func (tx *Tx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, cancel := withTimeout(ctx, tx.queryTimeout)
+	defer cancel()
+
+	res, err := tx.Tx.ExecContext(ctx, tx.Rebind(query), args...)
+	return res, QueryError(ctx, err)
+}
+
+// withTimeout returns a context that is done when ctx is or when the timeout elapses. A timeout of 0 never elapses.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// QueryError returns ErrTimeout or ErrCanceled if the query failed because ctx is done,
+// ErrDuplicate if it broke a unique index, and otherwise err.
+func QueryError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return ErrTimeout
+	case context.Canceled:
+		return ErrCanceled
+	}
+
+	if isUniqueViolation(err) {
+		return ErrDuplicate
+	}
+
+	return err
+}
+
+// isUniqueViolation returns true if the error is a driver's error for breaking a unique index.
+func isUniqueViolation(err error) bool {
+	switch e := errors.Cause(err).(type) {
+	case *mysql.MySQLError:
+		// ER_DUP_ENTRY
+		return e.Number == 1062
+	case *pq.Error:
+		return e.Code.Name() == "unique_violation"
+	case *sqlite.Error:
+		return e.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+	}
+
+	return false
+}
+
+func getConnectionString(cfg Config) (string, string, error) {
+	switch cfg.Dialect {
+	case MySQL, "":
+		return MySQL, mysqlConnectionString(cfg), nil
+	case Postgres:
+		return Postgres, postgresConnectionString(cfg.Postgres), nil
+	}
+
+	return "", "", fmt.Errorf("unknown database dialect: %s", cfg.Dialect)
+}
+
+func mysqlConnectionString(cfg Config) string {
 	if cfg.Port == 0 {
 		cfg.Port = 3306
 	}
 
-	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?tls=%t&multiStatements=%t",
+	// parseTime lets DATETIME columns be scanned into time.Time
+	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?tls=%t&multiStatements=%t&parseTime=true",
 		cfg.User,
 		cfg.Password,
 		cfg.Host,
@@ -79,6 +396,31 @@ func getConnectionString(cfg Config) string {
 	)
 }
 
+func postgresConnectionString(cfg PostgresConfig) string {
+	if cfg.Port == 0 {
+		cfg.Port = 5432
+	}
+	if cfg.SSLMode == "" {
+		cfg.SSLMode = "disable"
+	}
+
+	q := url.Values{}
+	q.Set("sslmode", cfg.SSLMode)
+	if cfg.SSLRootCert != "" {
+		q.Set("sslrootcert", cfg.SSLRootCert)
+	}
+
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(cfg.User, cfg.Password),
+		Host:     fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
+		Path:     "/" + cfg.DBName,
+		RawQuery: q.Encode(),
+	}
+
+	return u.String()
+}
+
 func pingDB(ctx context.Context, db *sqlx.DB) error {
 	return db.PingContext(ctx)
 }
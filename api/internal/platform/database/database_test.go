@@ -0,0 +1,153 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// newTestDB returns a DB for the dialect that is never connected to. sqlx.Open doesn't connect until the first query.
+func newTestDB(t *testing.T, dialect, dsn string) *DB {
+	t.Helper()
+
+	db, err := sqlx.Open(dialect, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return &DB{DB: db, dialect: dialect}
+}
+
+func TestRebind(t *testing.T) {
+	const query = `SELECT id FROM users WHERE email = ? AND deleted_at IS NULL AND id > ? LIMIT ?`
+
+	tests := []struct {
+		dialect string
+		dsn     string
+		want    string
+	}{
+		{
+			dialect: MySQL,
+			dsn:     "root@tcp(localhost:3306)/example",
+			want:    query,
+		},
+		{
+			dialect: Postgres,
+			dsn:     "postgres://postgres@localhost:5432/example?sslmode=disable",
+			want:    `SELECT id FROM users WHERE email = $1 AND deleted_at IS NULL AND id > $2 LIMIT $3`,
+		},
+		{
+			dialect: SQLite,
+			dsn:     ":memory:",
+			want:    query,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dialect, func(t *testing.T) {
+			db := newTestDB(t, tt.dialect, tt.dsn)
+
+			if db.Dialect() != tt.dialect {
+				t.Errorf("Dialect() = %s, want %s", db.Dialect(), tt.dialect)
+			}
+
+			got := db.Rebind(query)
+			if got != tt.want {
+				t.Errorf("Rebind() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTxRebind(t *testing.T) {
+	db, err := Open(SQLite, ":memory:", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`CREATE TABLE users (id INTEGER PRIMARY KEY, email VARCHAR(100) NOT NULL UNIQUE)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT INTO users (email) VALUES (?)`, "a@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var email string
+	err = tx.Get(&email, `SELECT email FROM users WHERE email = ?`, "a@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Breaking the unique index is reported as ErrDuplicate from within a transaction too
+	_, err = tx.Exec(`INSERT INTO users (email) VALUES (?)`, "a@example.com")
+	if err != ErrDuplicate {
+		t.Errorf("duplicate insert error = %v, want %v", err, ErrDuplicate)
+	}
+}
+
+func TestOpenQueryTimeout(t *testing.T) {
+	db, err := Open(SQLite, ":memory:", time.Nanosecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var n int
+	err = db.Get(&n, `SELECT 1`)
+	if err != ErrTimeout {
+		t.Errorf("Get() error = %v, want %v", err, ErrTimeout)
+	}
+}
+
+func TestQueryError(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	timedOut, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+
+	other := errors.New("syntax error")
+	syntax := &mysql.MySQLError{Number: 1064}
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want error
+	}{
+		{name: "no error", ctx: context.Background(), err: nil, want: nil},
+		{name: "other error", ctx: context.Background(), err: other, want: other},
+		{name: "canceled", ctx: canceled, err: other, want: ErrCanceled},
+		{name: "timed out", ctx: timedOut, err: other, want: ErrTimeout},
+		{name: "mysql duplicate", ctx: context.Background(), err: &mysql.MySQLError{Number: 1062}, want: ErrDuplicate},
+		{name: "mysql other", ctx: context.Background(), err: syntax, want: syntax},
+		{name: "postgres duplicate", ctx: context.Background(), err: errors.Wrap(&pq.Error{Code: "23505"}, "insert"), want: ErrDuplicate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := QueryError(tt.ctx, tt.err)
+			if got != tt.want {
+				t.Errorf("QueryError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
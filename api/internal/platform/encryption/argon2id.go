@@ -0,0 +1,117 @@
+package encryption
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	argon2idPrefix    = "$argon2id$"
+	argon2idSaltLen   = 16
+	argon2idKeyLen    = 32
+	argon2idSeparator = "$"
+)
+
+// argon2idAlgorithm hashes passwords with argon2id. Hashes are encoded in the PHC string format:
+//
+//	$argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<hash>
+type argon2idAlgorithm struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+}
+
+// argon2idHash is a decoded argon2id hash.
+type argon2idHash struct {
+	version int
+	argon2idAlgorithm
+	salt []byte
+	key  []byte
+}
+
+func isArgon2id(hash string) bool {
+	return strings.HasPrefix(hash, argon2idPrefix)
+}
+
+func (a argon2idAlgorithm) hash(pass string) (string, error) {
+	salt := make([]byte, argon2idSaltLen)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(pass), salt, a.time, a.memory, a.threads, argon2idKeyLen)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix,
+		argon2.Version,
+		a.memory,
+		a.time,
+		a.threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (a argon2idAlgorithm) outdated(hash string) bool {
+	h, err := decodeArgon2id(hash)
+	if err != nil {
+		return true
+	}
+
+	return h.version != argon2.Version || h.argon2idAlgorithm != a || len(h.key) != argon2idKeyLen
+}
+
+func (a argon2idAlgorithm) maxPasswordBytes() int {
+	return 0
+}
+
+// compareArgon2id hashes the password with the hash's own salt and parameters and compares the result.
+func compareArgon2id(hash, pass string) bool {
+	h, err := decodeArgon2id(hash)
+	if err != nil || h.version != argon2.Version {
+		return false
+	}
+
+	key := argon2.IDKey([]byte(pass), h.salt, h.time, h.memory, h.threads, uint32(len(h.key)))
+
+	return subtle.ConstantTimeCompare(key, h.key) == 1
+}
+
+// decodeArgon2id parses an argon2id hash in the PHC string format.
+func decodeArgon2id(hash string) (argon2idHash, error) {
+	// The leading separator makes the first part empty
+	parts := strings.Split(hash, argon2idSeparator)
+	if len(parts) != 6 || parts[1] != Argon2id {
+		return argon2idHash{}, fmt.Errorf("malformed argon2id hash")
+	}
+
+	h := argon2idHash{}
+
+	_, err := fmt.Sscanf(parts[2], "v=%d", &h.version)
+	if err != nil {
+		return argon2idHash{}, fmt.Errorf("malformed argon2id version: %s", err)
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads)
+	if err != nil {
+		return argon2idHash{}, fmt.Errorf("malformed argon2id parameters: %s", err)
+	}
+
+	h.salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return argon2idHash{}, fmt.Errorf("malformed argon2id salt: %s", err)
+	}
+
+	h.key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(h.key) == 0 {
+		return argon2idHash{}, fmt.Errorf("malformed argon2id hash: %v", err)
+	}
+
+	return h, nil
+}
@@ -0,0 +1,48 @@
+package encryption
+
+import (
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// bcryptAlgorithm hashes passwords with bcrypt. Hashes are encoded in bcrypt's own modular crypt format:
+//
+//	$2a$<cost>$<salt and hash>
+type bcryptAlgorithm struct {
+	cost int
+}
+
+// bcryptMaxPasswordBytes is the most bytes of a password bcrypt uses. Longer passwords can't be hashed.
+const bcryptMaxPasswordBytes = 72
+
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func (a bcryptAlgorithm) hash(pass string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(pass), a.cost)
+	return string(hash), err
+}
+
+func (a bcryptAlgorithm) outdated(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return true
+	}
+
+	return cost != a.cost
+}
+
+func (a bcryptAlgorithm) maxPasswordBytes() int {
+	return bcryptMaxPasswordBytes
+}
+
+func compareBcrypt(hash, pass string) bool {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass))
+	if err != nil {
+		return false
+	}
+
+	return true
+}
@@ -1,19 +1,196 @@
 package encryption
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"sync"
 
-const salt = 10
+	"github.com/pkg/errors"
+)
 
-func Encrypt(pass string) (string, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(pass), salt)
-	return string(hash), err
+/*
+	Passwords are hashed by a Hasher using the configured algorithm. Every hash records the algorithm
+	and the parameters it was made with, so hashes made with older settings can still be compared and
+	NeedsRehash can tell when a hash should be replaced with one made with the current settings.
+
+	The package level functions use the Hasher set up by Configure. Until Configure is called they
+	use the default Config.
+*/
+
+// Algorithms a Hasher can hash passwords with.
+const (
+	Argon2id = "argon2id"
+	Bcrypt   = "bcrypt"
+)
+
+// ErrUnsupportedAlgorithm is the error returned when a Hasher is configured with an algorithm it doesn't support.
+var ErrUnsupportedAlgorithm = errors.New("unsupported hash algorithm")
+
+// Config holds the configuration for hashing passwords.
+type Config struct {
+	// Algorithm is the algorithm new hashes are made with: argon2id or bcrypt.
+	Algorithm string `envconfig:"PASSWORD_HASH_ALGORITHM" default:"argon2id"`
+
+	// Argon2Memory is the amount of memory used by argon2id in KiB.
+	Argon2Memory uint32 `envconfig:"PASSWORD_ARGON2_MEMORY" default:"65536"`
+
+	// Argon2Time is the number of passes argon2id makes over the memory.
+	Argon2Time uint32 `envconfig:"PASSWORD_ARGON2_TIME" default:"3"`
+
+	// Argon2Threads is the number of threads argon2id uses.
+	Argon2Threads uint8 `envconfig:"PASSWORD_ARGON2_THREADS" default:"2"`
+
+	// BcryptCost is the cost bcrypt hashes are made with.
+	BcryptCost int `envconfig:"PASSWORD_BCRYPT_COST" default:"10"`
 }
 
-func Compare(hash, pass string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass))
+// algorithm hashes passwords with one algorithm.
+type algorithm interface {
+	// hash returns the encoded hash of the password.
+	hash(pass string) (string, error)
+
+	// outdated returns true if the encoded hash was made by this algorithm with different parameters.
+	outdated(hash string) bool
+
+	// maxPasswordBytes returns the longest password in bytes the algorithm hashes all of, or 0 if there is no limit.
+	maxPasswordBytes() int
+}
+
+// Hasher hashes passwords with the configured algorithm and compares passwords against hashes made with any supported algorithm.
+type Hasher struct {
+	current   algorithm
+	dummyOnce sync.Once
+	dummyHash string
+}
+
+// New returns a Hasher with the provided configuration.
+func New(c Config) (*Hasher, error) {
+	var a algorithm
+	switch c.Algorithm {
+	case Argon2id:
+		a = argon2idAlgorithm{
+			memory:  c.Argon2Memory,
+			time:    c.Argon2Time,
+			threads: c.Argon2Threads,
+		}
+	case Bcrypt:
+		a = bcryptAlgorithm{cost: c.BcryptCost}
+	default:
+		return nil, errors.Wrap(ErrUnsupportedAlgorithm, c.Algorithm)
+	}
+
+	return &Hasher{current: a}, nil
+}
+
+// Encrypt returns the hash of the password made with the configured algorithm.
+func (h *Hasher) Encrypt(pass string) (string, error) {
+	return h.current.hash(pass)
+}
+
+// Compare returns true if the password matches the hash.
+func (h *Hasher) Compare(hash, pass string) bool {
+	switch {
+	case isArgon2id(hash):
+		return compareArgon2id(hash, pass)
+	case isBcrypt(hash):
+		return compareBcrypt(hash, pass)
+	}
+
+	return false
+}
+
+// NeedsRehash returns true if the hash wasn't made with the configured algorithm and parameters.
+// It should be replaced with a new hash the next time the password is known.
+func (h *Hasher) NeedsRehash(hash string) bool {
+	switch h.current.(type) {
+	case argon2idAlgorithm:
+		if !isArgon2id(hash) {
+			return true
+		}
+	case bcryptAlgorithm:
+		if !isBcrypt(hash) {
+			return true
+		}
+	}
+
+	return h.current.outdated(hash)
+}
+
+// MaxPasswordBytes returns the longest password in bytes the configured algorithm can hash, or 0 if there is no limit.
+func (h *Hasher) MaxPasswordBytes() int {
+	return h.current.maxPasswordBytes()
+}
+
+// CompareDummy does the same amount of work as Compare and always returns false.
+// It is used when there is no hash to compare against so the caller can't be timed to find out why.
+func (h *Hasher) CompareDummy(pass string) bool {
+	// The dummy hash is made with the current settings so comparing against it takes as long as a real comparison.
+	h.dummyOnce.Do(func() {
+		b := make([]byte, 32)
+		_, err := rand.Read(b)
+		if err != nil {
+			return
+		}
+
+		h.dummyHash, _ = h.current.hash(base64.RawStdEncoding.EncodeToString(b))
+	})
+
+	h.Compare(h.dummyHash, pass)
+	return false
+}
+
+// std is the Hasher used by the package level functions.
+var std = mustNew(Config{
+	Algorithm:     Argon2id,
+	Argon2Memory:  64 * 1024,
+	Argon2Time:    3,
+	Argon2Threads: 2,
+	BcryptCost:    10,
+})
+
+func mustNew(c Config) *Hasher {
+	h, err := New(c)
 	if err != nil {
-		return false
+		panic(err)
 	}
 
-	return true
+	return h
+}
+
+// Configure replaces the Hasher used by the package level functions with one using the provided configuration.
+// It should be called once at startup, before any passwords are hashed.
+func Configure(c Config) error {
+	h, err := New(c)
+	if err != nil {
+		return err
+	}
+
+	std = h
+	return nil
+}
+
+// Encrypt returns the hash of the password made with the configured algorithm.
+func Encrypt(pass string) (string, error) {
+	return std.Encrypt(pass)
+}
+
+// Compare returns true if the password matches the hash.
+func Compare(hash, pass string) bool {
+	return std.Compare(hash, pass)
+}
+
+// NeedsRehash returns true if the hash wasn't made with the configured algorithm and parameters.
+func NeedsRehash(hash string) bool {
+	return std.NeedsRehash(hash)
+}
+
+// MaxPasswordBytes returns the longest password in bytes the configured algorithm can hash, or 0 if there is no limit.
+func MaxPasswordBytes() int {
+	return std.MaxPasswordBytes()
+}
+
+// CompareDummy does the same amount of work as Compare and always returns false.
+// It is used when there is no hash to compare against so the caller can't be timed to find out why.
+func CompareDummy(pass string) bool {
+	return std.CompareDummy(pass)
 }
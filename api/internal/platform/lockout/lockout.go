@@ -0,0 +1,214 @@
+package lockout
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrLocked is the error returned when an account has been locked after too many failed logins.
+	ErrLocked = errors.New("account locked")
+
+	// ErrBackoff is the error returned when a login is attempted before the backoff delay has elapsed.
+	ErrBackoff = errors.New("too many attempts")
+)
+
+// UntilUnlocked is the wait returned with ErrLocked when the lockout doesn't expire, because LockoutDuration is 0.
+const UntilUnlocked time.Duration = -1
+
+// Config holds all of the configuration for login brute-force protection
+type Config struct {
+	// FreeAttempts is how many failures are allowed for an email before each further attempt is delayed.
+	FreeAttempts int `envconfig:"LOGIN_FREE_ATTEMPTS" default:"3"`
+
+	// IPFreeAttempts is how many failures are allowed from a client IP before each further attempt is delayed.
+	IPFreeAttempts int `envconfig:"LOGIN_IP_FREE_ATTEMPTS" default:"20"`
+
+	// BaseDelay is the delay after the first failure past the free attempts. It doubles with every further failure.
+	BaseDelay time.Duration `envconfig:"LOGIN_BASE_DELAY" default:"1s"`
+
+	// MaxDelay caps the backoff delay.
+	MaxDelay time.Duration `envconfig:"LOGIN_MAX_DELAY" default:"5m"`
+
+	// LockoutThreshold is how many failures lock an email's account.
+	LockoutThreshold int `envconfig:"LOGIN_LOCKOUT_THRESHOLD" default:"10"`
+
+	// LockoutDuration is how long an account stays locked unless it is unlocked. 0 keeps it locked until it is unlocked.
+	LockoutDuration time.Duration `envconfig:"LOGIN_LOCKOUT_DURATION" default:"1h"`
+
+	// Window is how long failures are remembered after the most recent one.
+	Window time.Duration `envconfig:"LOGIN_FAILURE_WINDOW" default:"1h"`
+}
+
+// Guard tracks failed logins per email and per client IP.
+type Guard struct {
+	cache     *redis.Client
+	cfg       Config
+	keyPrefix string
+}
+
+// New returns a new Guard storing its counters in the provided cache.
+func New(c *redis.Client, cfg Config) *Guard {
+	return &Guard{
+		cache:     c,
+		cfg:       cfg,
+		keyPrefix: "lockout:",
+	}
+}
+
+func (g *Guard) emailKey(kind, email string) string {
+	return fmt.Sprintf("%s%s:email:%s", g.keyPrefix, kind, strings.ToLower(strings.TrimSpace(email)))
+}
+
+func (g *Guard) ipKey(kind, ip string) string {
+	return fmt.Sprintf("%s%s:ip:%s", g.keyPrefix, kind, ip)
+}
+
+// attemptScript checks that the email and IP aren't locked or backing off, and if they aren't records the attempt as a failure.
+// It runs as one script so concurrent attempts can't all pass the check before any of them is counted.
+var attemptScript = redis.NewScript(`
+-- A lock without an expiry has a PTTL of -1, and only a missing lock has -2
+local locked = redis.call('PTTL', KEYS[1])
+if locked ~= -2 then
+	return {1, locked}
+end
+
+local wait = math.max(redis.call('PTTL', KEYS[2]), redis.call('PTTL', KEYS[3]))
+if wait > 0 then
+	return {2, wait}
+end
+
+local window = tonumber(ARGV[1])
+local base = tonumber(ARGV[4])
+local max = tonumber(ARGV[5])
+local threshold = tonumber(ARGV[6])
+
+local function delay(failures, free)
+	local over = failures - free
+	if over <= 0 then
+		return 0
+	end
+
+	local d = base * 2 ^ (over - 1)
+	if d > max or d <= 0 then
+		d = max
+	end
+
+	return math.floor(d)
+end
+
+local emailFailures = redis.call('INCR', KEYS[4])
+redis.call('PEXPIRE', KEYS[4], window)
+local ipFailures = redis.call('INCR', KEYS[5])
+redis.call('PEXPIRE', KEYS[5], window)
+
+local d = delay(emailFailures, tonumber(ARGV[2]))
+if d > 0 then
+	redis.call('SET', KEYS[2], emailFailures, 'PX', d)
+end
+
+d = delay(ipFailures, tonumber(ARGV[3]))
+if d > 0 then
+	redis.call('SET', KEYS[3], ipFailures, 'PX', d)
+end
+
+if threshold > 0 and emailFailures >= threshold then
+	if tonumber(ARGV[7]) > 0 then
+		redis.call('SET', KEYS[1], ARGV[8], 'PX', ARGV[7])
+	else
+		redis.call('SET', KEYS[1], ARGV[8])
+	end
+end
+
+return {0, 0}
+`)
+
+// refundScript takes back an attempt from the IP's failures, if they haven't expired.
+var refundScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return redis.call('DECR', KEYS[1])
+end
+return 0
+`)
+
+// Attempt returns ErrLocked if the email's account is locked, or ErrBackoff along with how long
+// to wait if the email or IP have failed too many times recently.
+// Otherwise it counts the attempt as a failed login and returns nil. Call Succeed if the password turns out to be right.
+// Checking and counting happen atomically, so parallel guesses can't get past the backoff.
+func (g *Guard) Attempt(email, ip string) (time.Duration, error) {
+	keys := []string{
+		g.emailKey("locked", email),
+		g.emailKey("delay", email),
+		g.ipKey("delay", ip),
+		g.emailKey("failures", email),
+		g.ipKey("failures", ip),
+	}
+
+	res, err := attemptScript.Run(g.cache, keys,
+		milliseconds(g.cfg.Window),
+		g.cfg.FreeAttempts,
+		g.cfg.IPFreeAttempts,
+		milliseconds(g.cfg.BaseDelay),
+		milliseconds(g.cfg.MaxDelay),
+		g.cfg.LockoutThreshold,
+		milliseconds(g.cfg.LockoutDuration),
+		time.Now().UTC().Unix(),
+	).Result()
+	if err != nil {
+		return 0, errors.Wrap(err, "check lockout")
+	}
+
+	vals, ok := res.([]interface{})
+	if !ok || len(vals) != 2 {
+		return 0, errors.Errorf("check lockout: unexpected result %v", res)
+	}
+
+	code, _ := vals[0].(int64)
+	wait, _ := vals[1].(int64)
+
+	switch code {
+	case 1:
+		if wait < 0 {
+			return UntilUnlocked, ErrLocked
+		}
+		return time.Duration(wait) * time.Millisecond, ErrLocked
+	case 2:
+		return time.Duration(wait) * time.Millisecond, ErrBackoff
+	}
+
+	return 0, nil
+}
+
+// milliseconds returns d in whole milliseconds, which is what the scripts work in.
+func milliseconds(d time.Duration) int64 {
+	return int64(d / time.Millisecond)
+}
+
+// Succeed clears the email's failures after a successful login, and takes the attempt back from the IP's failures.
+// The IP's earlier failures and backoff are kept, since one good password doesn't mean the IP isn't guessing at other accounts.
+func (g *Guard) Succeed(email, ip string) error {
+	err := refundScript.Run(g.cache, []string{g.ipKey("failures", ip)}).Err()
+	if err != nil && err != redis.Nil {
+		return errors.Wrap(err, "refund attempt")
+	}
+
+	return g.Unlock(email)
+}
+
+// Unlock clears the email's failures, backoff and lockout.
+func (g *Guard) Unlock(email string) error {
+	err := g.cache.Del(
+		g.emailKey("failures", email),
+		g.emailKey("delay", email),
+		g.emailKey("locked", email),
+	).Err()
+	if err != nil {
+		return errors.Wrap(err, "unlock")
+	}
+
+	return nil
+}
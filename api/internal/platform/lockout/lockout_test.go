@@ -0,0 +1,191 @@
+package lockout
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+)
+
+// newTestGuard returns a Guard backed by an in-memory redis, along with the server so tests can inspect it.
+func newTestGuard(t *testing.T, cfg Config) (*Guard, *miniredis.Miniredis) {
+	t.Helper()
+
+	s := miniredis.RunT(t)
+	c := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	t.Cleanup(func() { c.Close() })
+
+	return New(c, cfg), s
+}
+
+func TestAttemptBacksOffAfterFreeAttempts(t *testing.T) {
+	g, _ := newTestGuard(t, Config{
+		FreeAttempts:   2,
+		IPFreeAttempts: 100,
+		BaseDelay:      time.Second,
+		MaxDelay:       time.Minute,
+		Window:         time.Hour,
+	})
+
+	for i := 0; i < 3; i++ {
+		_, err := g.Attempt("a@example.com", "10.0.0.1")
+		if err != nil {
+			t.Fatalf("attempt %d: %v", i+1, err)
+		}
+	}
+
+	wait, err := g.Attempt("a@example.com", "10.0.0.1")
+	if err != ErrBackoff {
+		t.Fatalf("err = %v, want %v", err, ErrBackoff)
+	}
+	if wait <= 0 || wait > time.Second {
+		t.Errorf("wait = %s, want up to 1s", wait)
+	}
+
+	// Other emails aren't held back by this one
+	_, err = g.Attempt("b@example.com", "10.0.0.1")
+	if err != nil {
+		t.Errorf("other email: %v", err)
+	}
+}
+
+func TestAttemptLocksAtThreshold(t *testing.T) {
+	g, s := newTestGuard(t, Config{
+		FreeAttempts:     100,
+		IPFreeAttempts:   100,
+		LockoutThreshold: 3,
+		LockoutDuration:  time.Hour,
+		Window:           time.Hour,
+	})
+
+	for i := 0; i < 3; i++ {
+		_, err := g.Attempt("a@example.com", "10.0.0.1")
+		if err != nil {
+			t.Fatalf("attempt %d: %v", i+1, err)
+		}
+	}
+
+	wait, err := g.Attempt("a@example.com", "10.0.0.1")
+	if err != ErrLocked {
+		t.Fatalf("err = %v, want %v", err, ErrLocked)
+	}
+	if wait != time.Hour {
+		t.Errorf("wait = %s, want 1h", wait)
+	}
+
+	// The lock expires on its own
+	s.FastForward(time.Hour)
+
+	_, err = g.Attempt("a@example.com", "10.0.0.1")
+	if err != nil {
+		t.Errorf("after the lockout: %v", err)
+	}
+}
+
+func TestAttemptLocksUntilUnlockedWithoutDuration(t *testing.T) {
+	g, s := newTestGuard(t, Config{
+		FreeAttempts:     100,
+		IPFreeAttempts:   100,
+		LockoutThreshold: 1,
+		Window:           time.Hour,
+	})
+
+	_, err := g.Attempt("a@example.com", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wait, err := g.Attempt("a@example.com", "10.0.0.1")
+	if err != ErrLocked {
+		t.Fatalf("err = %v, want %v", err, ErrLocked)
+	}
+	if wait != UntilUnlocked {
+		t.Errorf("wait = %s, want %s", wait, UntilUnlocked)
+	}
+
+	// Time doesn't help, only unlocking does
+	s.FastForward(24 * time.Hour)
+
+	_, err = g.Attempt("a@example.com", "10.0.0.1")
+	if err != ErrLocked {
+		t.Fatalf("err after a day = %v, want %v", err, ErrLocked)
+	}
+
+	err = g.Unlock("a@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = g.Attempt("a@example.com", "10.0.0.1")
+	if err != nil {
+		t.Errorf("after unlock: %v", err)
+	}
+}
+
+func TestSucceedClearsEmailAndRefundsIP(t *testing.T) {
+	g, s := newTestGuard(t, Config{
+		FreeAttempts:   100,
+		IPFreeAttempts: 100,
+		Window:         time.Hour,
+	})
+
+	for i := 0; i < 2; i++ {
+		_, err := g.Attempt("a@example.com", "10.0.0.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := g.Succeed("a@example.com", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Exists(g.emailKey("failures", "a@example.com")) {
+		t.Error("email failures weren't cleared")
+	}
+
+	// Only the successful attempt is taken back from the IP
+	got, err := s.Get(g.ipKey("failures", "10.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "1" {
+		t.Errorf("ip failures = %s, want 1", got)
+	}
+}
+
+func TestAttemptIsAtomic(t *testing.T) {
+	g, _ := newTestGuard(t, Config{
+		FreeAttempts:   1,
+		IPFreeAttempts: 100,
+		BaseDelay:      time.Minute,
+		MaxDelay:       time.Hour,
+		Window:         time.Hour,
+	})
+
+	// Only the free attempt and the one that starts the backoff can get through, however many race
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := g.Attempt("a@example.com", "10.0.0.1")
+			if err == nil {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed != 2 {
+		t.Errorf("%d attempts got through, want 2", allowed)
+	}
+}
@@ -0,0 +1,195 @@
+package mail
+
+import (
+	"fmt"
+	"io"
+	"net/smtp"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Config holds all of the configuration for sending mail
+type Config struct {
+	// Driver selects how mail is sent: "stdout", "file" or "smtp".
+	Driver string `envconfig:"MAIL_DRIVER" default:"stdout"`
+	From   string `envconfig:"MAIL_FROM" default:"no-reply@localhost"`
+
+	// BaseURL is the URL links in emails are built from, usually the client app.
+	BaseURL string `envconfig:"MAIL_BASE_URL" default:"http://localhost:8080"`
+
+	// APIBaseURL is the URL links in emails to the API itself are built from.
+	APIBaseURL string `envconfig:"MAIL_API_BASE_URL" default:"http://localhost:3000"`
+
+	// FilePath is where mail is appended when using the file driver.
+	FilePath string `envconfig:"MAIL_FILE_PATH" default:"mail.log"`
+
+	SMTPHost     string `envconfig:"SMTP_HOST" default:"localhost"`
+	SMTPPort     int    `envconfig:"SMTP_PORT" default:"25"`
+	SMTPUser     string `envconfig:"SMTP_USER"`
+	SMTPPassword string `envconfig:"SMTP_PASSWORD"`
+}
+
+// Message is an email
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers messages. Implement it to send mail through another provider.
+type Sender interface {
+	Send(m Message) error
+}
+
+// Mailer sends mail from the configured address using a Sender.
+type Mailer struct {
+	sender     Sender
+	from       string
+	baseURL    string
+	apiBaseURL string
+}
+
+// New returns a Mailer using the Sender selected by the config's driver.
+func New(cfg Config) (*Mailer, error) {
+	var s Sender
+	switch cfg.Driver {
+	case "", "stdout":
+		s = NewWriterSender(os.Stdout)
+	case "file":
+		s = NewFileSender(cfg.FilePath)
+	case "smtp":
+		s = NewSMTPSender(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUser, cfg.SMTPPassword)
+	default:
+		return nil, errors.Errorf("unknown mail driver: %s", cfg.Driver)
+	}
+
+	return NewWithSender(cfg, s), nil
+}
+
+// NewWithSender returns a Mailer that delivers mail through the provided Sender.
+func NewWithSender(cfg Config, s Sender) *Mailer {
+	return &Mailer{
+		sender:     s,
+		from:       cfg.From,
+		baseURL:    strings.TrimRight(cfg.BaseURL, "/"),
+		apiBaseURL: strings.TrimRight(cfg.APIBaseURL, "/"),
+	}
+}
+
+// Send sends an email to the provided address.
+func (m *Mailer) Send(to, subject, body string) error {
+	err := m.sender.Send(Message{
+		From:    m.from,
+		To:      to,
+		Subject: subject,
+		Body:    body,
+	})
+	if err != nil {
+		return errors.Wrap(err, "send mail")
+	}
+
+	return nil
+}
+
+// Link returns an absolute link to the provided path of the client app with the query.
+func (m *Mailer) Link(path string, query url.Values) string {
+	return link(m.baseURL, path, query)
+}
+
+// APILink returns an absolute link to the provided path of the API with the query.
+func (m *Mailer) APILink(path string, query url.Values) string {
+	return link(m.apiBaseURL, path, query)
+}
+
+// link returns an absolute link to the path under base with the query.
+func link(base, path string, query url.Values) string {
+	return fmt.Sprintf("%s/%s?%s", base, strings.TrimLeft(path, "/"), query.Encode())
+}
+
+// format returns the message as an RFC 5322 email.
+func format(m Message) string {
+	return fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n",
+		m.From,
+		m.To,
+		m.Subject,
+		time.Now().Format(time.RFC1123Z),
+		m.Body,
+	)
+}
+
+// WriterSender writes every message to an io.Writer. It is meant for local development.
+type WriterSender struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterSender returns a Sender that writes messages to w.
+func NewWriterSender(w io.Writer) *WriterSender {
+	return &WriterSender{w: w}
+}
+
+// Send implements Sender.
+func (s *WriterSender) Send(m Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := io.WriteString(s.w, format(m)+"\r\n")
+	return err
+}
+
+// FileSender appends every message to a file. It is meant for local development.
+type FileSender struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileSender returns a Sender that appends messages to the file at path.
+func NewFileSender(path string) *FileSender {
+	return &FileSender{path: path}
+}
+
+// Send implements Sender.
+func (s *FileSender) Send(m Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "open file: %s", s.path)
+	}
+	defer f.Close()
+
+	_, err = io.WriteString(f, format(m)+"\r\n")
+	return err
+}
+
+// SMTPSender sends every message through an SMTP server.
+type SMTPSender struct {
+	addr string
+	auth smtp.Auth
+}
+
+// NewSMTPSender returns a Sender that delivers messages through the SMTP server.
+// If user is empty, no authentication is used.
+func NewSMTPSender(host string, port int, user, password string) *SMTPSender {
+	s := &SMTPSender{
+		addr: fmt.Sprintf("%s:%d", host, port),
+	}
+
+	if user != "" {
+		s.auth = smtp.PlainAuth("", user, password, host)
+	}
+
+	return s
+}
+
+// Send implements Sender.
+func (s *SMTPSender) Send(m Message) error {
+	return smtp.SendMail(s.addr, s.auth, m.From, []string{m.To}, []byte(format(m)))
+}
@@ -0,0 +1,525 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jongschneider/youtube-project/api/internal/platform/database"
+	"github.com/pkg/errors"
+)
+
+/*
+	Migrations are pairs of SQL files in a directory, named after their version and a description:
+
+		0001_create_users.up.sql
+		0001_create_users.down.sql
+
+	Each dialect has its own directory of migrations, named after the dialect, in the migrations directory:
+
+		mysql/0001_create_users.up.sql
+		postgres/0001_create_users.up.sql
+		sqlite/0001_create_users.up.sql
+
+	They are applied in order of their version. Every applied migration is recorded in the
+	schema_migrations table, and only one Migrator can apply or roll back migrations at a time,
+	so multiple replicas starting at once don't race each other.
+
+	Each migration is applied in a transaction along with its record. On postgres that makes it atomic,
+	but MySQL commits implicitly after every DDL statement, so there a migration that fails part way
+	leaves the statements before the failure applied and isn't recorded. Keep MySQL migrations to
+	one DDL statement where possible, and fix a failed one by hand before migrating again.
+
+	Seeds are SQL files of development data, such as a local admin user, kept out of the migrations so
+	they never reach other environments. They live in a directory per dialect like the migrations, are
+	run in order of their file names, aren't recorded, and have to be safe to run more than once.
+*/
+
+// lockName is the name of the advisory lock held while migrating.
+const lockName = "schema_migrations"
+
+// lockKey identifies the advisory lock on postgres, which are keyed by a number instead of a name.
+const lockKey int64 = 7297634612
+
+// lockPollInterval is how often postgres is asked for the lock while another Migrator holds it.
+const lockPollInterval = time.Second
+
+var (
+	// ErrLocked is the error returned when another Migrator held the lock for longer than the lock timeout.
+	ErrLocked = errors.New("migrations are locked")
+
+	// ErrUnknownVersion is the error returned when migrating to a version that has no migration.
+	ErrUnknownVersion = errors.New("unknown migration version")
+
+	// ErrIrreversible is the error returned when rolling back a migration that has no down SQL.
+	ErrIrreversible = errors.New("irreversible migration")
+)
+
+// fileName matches the name of a migration file: <version>_<name>.<up|down>.sql
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Config holds the configuration for a Migrator.
+type Config struct {
+	// Dir is the directory the migration files are loaded from. Migrations are loaded from the subdirectory named after the dialect.
+	Dir string `envconfig:"MIGRATIONS_DIR" default:"internal/schema/migrations"`
+
+	// FS is the file system Dir is in, such as the migrations embedded by the schema package. When it is nil, Dir is in the OS's file system.
+	FS fs.FS `ignored:"true"`
+
+	// OnStartup should be true if the API applies pending migrations when it starts.
+	// When it isn't set, migrations are only applied on startup in the local environment. See ApplyOnStartup.
+	OnStartup *bool `envconfig:"MIGRATE_ON_STARTUP"`
+
+	// SeedDir is the directory development data is loaded from by Seed. Seeds are loaded from the subdirectory named after the dialect.
+	SeedDir string `envconfig:"SEEDS_DIR" default:"internal/schema/seeds"`
+
+	// LockTimeout is how long to wait for another Migrator to finish.
+	LockTimeout time.Duration `envconfig:"MIGRATE_LOCK_TIMEOUT" default:"1m"`
+}
+
+// ApplyOnStartup returns true if the API should apply pending migrations when it starts.
+// Unless OnStartup says otherwise, that is only in the local environment.
+func (c Config) ApplyOnStartup(local bool) bool {
+	if c.OnStartup != nil {
+		return *c.OnStartup
+	}
+
+	return local
+}
+
+// Migration is a change to the schema along with the SQL that undoes it.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status is a migration along with when it was applied. AppliedAt is nil if it hasn't been applied.
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Load loads the migrations in the directory, ordered by their version.
+func Load(dir string) ([]Migration, error) {
+	return LoadFS(os.DirFS(dir), ".")
+}
+
+// LoadFS loads the migrations in the directory of the file system, ordered by their version.
+func LoadFS(fsys fs.FS, dir string) ([]Migration, error) {
+	files, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "read migrations")
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, f := range files {
+		match := fileName.FindStringSubmatch(f.Name())
+		if f.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "migration version: %s", f.Name())
+		}
+
+		b, err := fs.ReadFile(fsys, path.Join(dir, f.Name()))
+		if err != nil {
+			return nil, errors.Wrapf(err, "read migration: %s", f.Name())
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+
+		if m.Name != match[2] {
+			return nil, errors.Errorf("migration %d has two names: %s and %s", version, m.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			m.Up = string(b)
+		} else {
+			m.Down = string(b)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, errors.Errorf("migration %d_%s has no up sql", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Migrator applies and rolls back migrations.
+type Migrator struct {
+	db          *database.DB
+	migrations  []Migration
+	lockTimeout time.Duration
+	seedDir     string
+}
+
+// New returns a Migrator for the migrations of the db's dialect in the configured directory.
+func New(db *database.DB, c Config) (*Migrator, error) {
+	fsys := c.FS
+	if fsys == nil {
+		fsys = os.DirFS(c.Dir)
+	} else {
+		sub, err := fs.Sub(c.FS, c.Dir)
+		if err != nil {
+			return nil, errors.Wrap(err, "migrations dir")
+		}
+		fsys = sub
+	}
+
+	migrations, err := LoadFS(fsys, db.Dialect())
+	if err != nil {
+		return nil, err
+	}
+
+	if c.LockTimeout == 0 {
+		c.LockTimeout = time.Minute
+	}
+
+	return &Migrator{
+		db:          db,
+		migrations:  migrations,
+		lockTimeout: c.LockTimeout,
+		seedDir:     filepath.Join(c.SeedDir, db.Dialect()),
+	}, nil
+}
+
+// Up applies every pending migration and returns the migrations it applied.
+func (m *Migrator) Up() ([]Migration, error) {
+	done := []Migration{}
+
+	err := m.locked(func(ctx context.Context, conn *sql.Conn, applied map[int64]time.Time) error {
+		for _, mig := range m.migrations {
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+
+			err := m.apply(ctx, conn, mig)
+			if err != nil {
+				return err
+			}
+			done = append(done, mig)
+		}
+
+		return nil
+	})
+
+	return done, err
+}
+
+// Down rolls back the most recently applied migrations, up to steps of them, and returns the migrations it rolled back.
+func (m *Migrator) Down(steps int) ([]Migration, error) {
+	done := []Migration{}
+
+	err := m.locked(func(ctx context.Context, conn *sql.Conn, applied map[int64]time.Time) error {
+		for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+			mig := m.migrations[i]
+			if _, ok := applied[mig.Version]; !ok {
+				continue
+			}
+
+			err := m.rollback(ctx, conn, mig)
+			if err != nil {
+				return err
+			}
+			done = append(done, mig)
+		}
+
+		return nil
+	})
+
+	return done, err
+}
+
+// To applies or rolls back migrations until every migration up to and including version is applied,
+// and none after it are. Version 0 rolls back every migration.
+// It returns the migrations it applied or rolled back, in the order it did so.
+func (m *Migrator) To(version int64) ([]Migration, error) {
+	if version != 0 {
+		found := false
+		for _, mig := range m.migrations {
+			found = found || mig.Version == version
+		}
+
+		if !found {
+			return nil, errors.Wrapf(ErrUnknownVersion, "%d", version)
+		}
+	}
+
+	done := []Migration{}
+
+	err := m.locked(func(ctx context.Context, conn *sql.Conn, applied map[int64]time.Time) error {
+		// Roll back the newest migrations first
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			mig := m.migrations[i]
+			if _, ok := applied[mig.Version]; !ok || mig.Version <= version {
+				continue
+			}
+
+			err := m.rollback(ctx, conn, mig)
+			if err != nil {
+				return err
+			}
+			done = append(done, mig)
+		}
+
+		for _, mig := range m.migrations {
+			if _, ok := applied[mig.Version]; ok || mig.Version > version {
+				continue
+			}
+
+			err := m.apply(ctx, conn, mig)
+			if err != nil {
+				return err
+			}
+			done = append(done, mig)
+		}
+
+		return nil
+	})
+
+	return done, err
+}
+
+// Status returns every migration along with when it was applied.
+func (m *Migrator) Status() ([]Status, error) {
+	statuses := []Status{}
+
+	err := m.locked(func(ctx context.Context, conn *sql.Conn, applied map[int64]time.Time) error {
+		for _, mig := range m.migrations {
+			s := Status{Migration: mig}
+			if t, ok := applied[mig.Version]; ok {
+				s.AppliedAt = &t
+			}
+			statuses = append(statuses, s)
+		}
+
+		return nil
+	})
+
+	return statuses, err
+}
+
+// locked runs fn on a single connection while holding the migration lock,
+// along with when each applied migration was applied.
+func (m *Migrator) locked(fn func(ctx context.Context, conn *sql.Conn, applied map[int64]time.Time) error) error {
+	ctx := context.Background()
+
+	// Advisory locks belong to a session, so everything has to happen on the same connection
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return errors.Wrap(err, "get connection")
+	}
+	defer conn.Close()
+
+	err = m.lock(ctx, conn)
+	if err != nil {
+		return err
+	}
+	defer m.unlock(ctx, conn)
+
+	timestamp := "DATETIME"
+	if m.db.Dialect() == database.Postgres {
+		timestamp = "TIMESTAMP"
+	}
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT NOT NULL,
+		name VARCHAR(255) NOT NULL,
+		applied_at `+timestamp+` NOT NULL,
+		PRIMARY KEY (version)
+	)`)
+	if err != nil {
+		return errors.Wrap(err, "create schema_migrations")
+	}
+
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return errors.Wrap(err, "get applied migrations")
+	}
+	defer rows.Close()
+
+	applied := map[int64]time.Time{}
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		err = rows.Scan(&version, &appliedAt)
+		if err != nil {
+			return errors.Wrap(err, "scan applied migration")
+		}
+		applied[version] = appliedAt
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return errors.Wrap(err, "get applied migrations")
+	}
+
+	return fn(ctx, conn, applied)
+}
+
+// apply runs the migration's up SQL and records it as applied.
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, mig Migration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "begin")
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, mig.Up)
+	if err != nil {
+		return errors.Wrapf(err, "apply %s", mig)
+	}
+
+	_, err = tx.ExecContext(ctx, m.db.Rebind(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`),
+		mig.Version, mig.Name, time.Now().UTC())
+	if err != nil {
+		return errors.Wrapf(err, "record %s", mig)
+	}
+
+	return errors.Wrapf(tx.Commit(), "commit %s", mig)
+}
+
+// rollback runs the migration's down SQL and removes its record.
+func (m *Migrator) rollback(ctx context.Context, conn *sql.Conn, mig Migration) error {
+	if mig.Down == "" {
+		return errors.Wrapf(ErrIrreversible, "%s", mig)
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "begin")
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, mig.Down)
+	if err != nil {
+		return errors.Wrapf(err, "roll back %s", mig)
+	}
+
+	_, err = tx.ExecContext(ctx, m.db.Rebind(`DELETE FROM schema_migrations WHERE version = ?`), mig.Version)
+	if err != nil {
+		return errors.Wrapf(err, "unrecord %s", mig)
+	}
+
+	return errors.Wrapf(tx.Commit(), "commit %s", mig)
+}
+
+// Seed runs every seed file for the db's dialect in order of their names and returns the names of the files it ran.
+// It should only be used on development databases.
+func (m *Migrator) Seed() ([]string, error) {
+	files, err := ioutil.ReadDir(m.seedDir)
+	if err != nil {
+		return nil, errors.Wrap(err, "read seeds")
+	}
+
+	done := []string{}
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != ".sql" {
+			continue
+		}
+
+		b, err := ioutil.ReadFile(filepath.Join(m.seedDir, f.Name()))
+		if err != nil {
+			return done, errors.Wrapf(err, "read seed: %s", f.Name())
+		}
+
+		_, err = m.db.DB.Exec(string(b))
+		if err != nil {
+			return done, errors.Wrapf(err, "seed %s", f.Name())
+		}
+		done = append(done, f.Name())
+	}
+
+	return done, nil
+}
+
+// String returns the migration's file name without the direction.
+func (mig Migration) String() string {
+	return fmt.Sprintf("%04d_%s", mig.Version, mig.Name)
+}
+
+// lock takes the migration lock, waiting up to the lock timeout for another Migrator to release it.
+func (m *Migrator) lock(ctx context.Context, conn *sql.Conn) error {
+	switch m.db.Dialect() {
+	case database.Postgres:
+		return lockPostgres(ctx, conn, m.lockTimeout)
+	case database.SQLite:
+		// SQLite has no advisory locks, and its databases belong to a single process anyway
+		return nil
+	}
+
+	var got sql.NullInt64
+	err := conn.QueryRowContext(ctx, `SELECT GET_LOCK(?, ?)`, lockName, int(m.lockTimeout/time.Second)).Scan(&got)
+	if err != nil {
+		return errors.Wrap(err, "get lock")
+	}
+
+	if !got.Valid || got.Int64 != 1 {
+		return ErrLocked
+	}
+
+	return nil
+}
+
+// lockPostgres takes the migration lock on postgres. Postgres can't wait for an advisory lock with a timeout,
+// so it is polled for instead.
+func lockPostgres(ctx context.Context, conn *sql.Conn, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		var got bool
+		err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, lockKey).Scan(&got)
+		if err != nil {
+			return errors.Wrap(err, "get lock")
+		}
+
+		if got {
+			return nil
+		}
+
+		if time.Now().Add(lockPollInterval).After(deadline) {
+			return ErrLocked
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// unlock releases the migration lock.
+func (m *Migrator) unlock(ctx context.Context, conn *sql.Conn) error {
+	switch m.db.Dialect() {
+	case database.Postgres:
+		_, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, lockKey)
+		return err
+	case database.SQLite:
+		return nil
+	}
+
+	_, err := conn.ExecContext(ctx, `SELECT RELEASE_LOCK(?)`, lockName)
+	return err
+}
@@ -0,0 +1,299 @@
+package migrate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jongschneider/youtube-project/api/internal/platform/database"
+	"github.com/jongschneider/youtube-project/api/internal/schema"
+	"github.com/pkg/errors"
+	_ "modernc.org/sqlite" // provides the pure go sqlite driver for sqlx
+)
+
+// newTestMigrator returns a Migrator for an in-memory database and the migration files, keyed by file name.
+func newTestMigrator(t *testing.T, files map[string]string) (*Migrator, *database.DB) {
+	t.Helper()
+
+	dir := t.TempDir()
+	writeFiles(t, filepath.Join(dir, database.SQLite), files)
+
+	db, err := database.Open(database.SQLite, ":memory:", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	// Every connection to :memory: is a new database
+	db.SetMaxOpenConns(1)
+
+	m, err := New(db, Config{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m, db
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, sql := range files {
+		err = os.WriteFile(filepath.Join(dir, name), []byte(sql), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// tableExists returns true if the db has the table.
+func tableExists(t *testing.T, db *database.DB, table string) bool {
+	t.Helper()
+
+	var n int
+	err := db.DB.Get(&n, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return n == 1
+}
+
+func versions(migrations []Migration) []int64 {
+	v := []int64{}
+	for _, m := range migrations {
+		v = append(v, m.Version)
+	}
+	return v
+}
+
+func equal(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+var testMigrations = map[string]string{
+	"0001_create_a.up.sql":   `CREATE TABLE a (id INTEGER PRIMARY KEY)`,
+	"0001_create_a.down.sql": `DROP TABLE a`,
+	"0002_create_b.up.sql":   `CREATE TABLE b (id INTEGER PRIMARY KEY)`,
+	"0002_create_b.down.sql": `DROP TABLE b`,
+	"0010_create_c.up.sql":   `CREATE TABLE c (id INTEGER PRIMARY KEY)`,
+	"0010_create_c.down.sql": `DROP TABLE c`,
+	"README.md":              `not a migration`,
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  []int64
+		ok    bool
+	}{
+		{name: "ordered by version", files: testMigrations, want: []int64{1, 2, 10}, ok: true},
+		{name: "down is optional", files: map[string]string{"0001_create_a.up.sql": `CREATE TABLE a (id INTEGER)`}, want: []int64{1}, ok: true},
+		{name: "missing up", files: map[string]string{"0001_create_a.down.sql": `DROP TABLE a`}},
+		{name: "two names", files: map[string]string{
+			"0001_create_a.up.sql": `CREATE TABLE a (id INTEGER)`,
+			"0001_create_b.up.sql": `CREATE TABLE b (id INTEGER)`,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tt.files)
+
+			migrations, err := Load(dir)
+			if (err == nil) != tt.ok {
+				t.Fatalf("Load() error = %v, want ok %v", err, tt.ok)
+			}
+
+			if tt.ok && !equal(versions(migrations), tt.want) {
+				t.Errorf("Load() versions = %v, want %v", versions(migrations), tt.want)
+			}
+		})
+	}
+}
+
+func TestUpDown(t *testing.T) {
+	m, db := newTestMigrator(t, testMigrations)
+
+	done, err := m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equal(versions(done), []int64{1, 2, 10}) {
+		t.Fatalf("Up() applied %v, want [1 2 10]", versions(done))
+	}
+	for _, table := range []string{"a", "b", "c"} {
+		if !tableExists(t, db, table) {
+			t.Errorf("table %s doesn't exist after Up()", table)
+		}
+	}
+
+	// Applied migrations aren't applied again
+	done, err = m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != 0 {
+		t.Errorf("second Up() applied %v, want nothing", versions(done))
+	}
+
+	done, err = m.Down(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equal(versions(done), []int64{10, 2}) {
+		t.Fatalf("Down(2) rolled back %v, want [10 2]", versions(done))
+	}
+	if tableExists(t, db, "b") || tableExists(t, db, "c") {
+		t.Error("tables b and c exist after Down(2)")
+	}
+	if !tableExists(t, db, "a") {
+		t.Error("table a doesn't exist after Down(2)")
+	}
+
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range statuses {
+		if applied := s.AppliedAt != nil; applied != (s.Version == 1) {
+			t.Errorf("Status() %s applied = %v", s.Migration, applied)
+		}
+	}
+}
+
+func TestTo(t *testing.T) {
+	m, db := newTestMigrator(t, testMigrations)
+
+	done, err := m.To(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equal(versions(done), []int64{1, 2}) {
+		t.Fatalf("To(2) applied %v, want [1 2]", versions(done))
+	}
+
+	done, err = m.To(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equal(versions(done), []int64{2}) {
+		t.Fatalf("To(1) rolled back %v, want [2]", versions(done))
+	}
+
+	done, err = m.To(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equal(versions(done), []int64{1}) {
+		t.Fatalf("To(0) rolled back %v, want [1]", versions(done))
+	}
+	if tableExists(t, db, "a") {
+		t.Error("table a exists after To(0)")
+	}
+
+	_, err = m.To(3)
+	if errors.Cause(err) != ErrUnknownVersion {
+		t.Errorf("To(3) error = %v, want %v", err, ErrUnknownVersion)
+	}
+}
+
+func TestDownIrreversible(t *testing.T) {
+	m, db := newTestMigrator(t, map[string]string{
+		"0001_create_a.up.sql": `CREATE TABLE a (id INTEGER PRIMARY KEY)`,
+	})
+
+	_, err := m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.Down(1)
+	if errors.Cause(err) != ErrIrreversible {
+		t.Errorf("Down(1) error = %v, want %v", err, ErrIrreversible)
+	}
+	if !tableExists(t, db, "a") {
+		t.Error("table a doesn't exist after an irreversible Down(1)")
+	}
+}
+
+func TestUpFailed(t *testing.T) {
+	m, db := newTestMigrator(t, map[string]string{
+		"0001_create_a.up.sql": `CREATE TABLE a (id INTEGER PRIMARY KEY)`,
+		"0002_create_b.up.sql": `CREATE TABLE b (id INTEGER PRIMARY KEY); INSERT INTO missing (id) VALUES (1);`,
+		"0003_create_c.up.sql": `CREATE TABLE c (id INTEGER PRIMARY KEY)`,
+	})
+
+	done, err := m.Up()
+	if err == nil {
+		t.Fatal("Up() error = nil, want the failed migration's error")
+	}
+	if !equal(versions(done), []int64{1}) {
+		t.Errorf("Up() applied %v, want [1]", versions(done))
+	}
+
+	// The failed migration is rolled back along with its record, and the ones after it aren't applied
+	if tableExists(t, db, "b") || tableExists(t, db, "c") {
+		t.Error("tables b or c exist after a failed Up()")
+	}
+
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range statuses {
+		if applied := s.AppliedAt != nil; applied != (s.Version == 1) {
+			t.Errorf("Status() %s applied = %v", s.Migration, applied)
+		}
+	}
+}
+
+func TestSchemaMigrations(t *testing.T) {
+	db, err := database.Open(database.SQLite, ":memory:", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+
+	m, err := New(db, Config{Dir: "migrations", FS: schema.Migrations})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every migration applies and rolls back cleanly, so the sqlite migrations stay usable as they are added to
+	up, err := m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(up) == 0 {
+		t.Fatal("Up() applied no migrations")
+	}
+
+	down, err := m.To(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(down) != len(up) {
+		t.Errorf("To(0) rolled back %d migrations, want %d", len(down), len(up))
+	}
+	if tableExists(t, db, "users") {
+		t.Error("table users exists after To(0)")
+	}
+}
@@ -0,0 +1,147 @@
+package oauth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/jongschneider/youtube-project/api/internal/platform/database"
+	"github.com/pkg/errors"
+)
+
+/*
+	Client secrets are generated by NewSecret rather than chosen by people, so they have enough randomness
+	that a SHA-256 digest protects them as well as a slow password hash would. Checking a digest costs next
+	to nothing, so unauthenticated requests can't be used to tie up the server's memory and CPU.
+
+	Clients registered before secrets were digested have an argon2id hash as their secret and can't
+	authenticate; give them a new secret with tools/oauthclient.
+*/
+
+// secretBytes is how much randomness is in a client secret.
+const secretBytes = 32
+
+// ErrInvalidClient is the error returned when a client can't be authenticated.
+var ErrInvalidClient = errors.New("invalid client")
+
+// Client represents an OAuth 2.0 client registered in the DB
+type Client struct {
+	ID string `json:"client_id" db:"id"`
+
+	// Secret is the hex SHA-256 digest of the client's secret. See HashSecret.
+	Secret string `json:"-" db:"secret"`
+
+	Scopes string `json:"scope" db:"scopes"`
+}
+
+// AllowedScopes returns the scopes the client may be granted.
+func (c Client) AllowedScopes() []string {
+	return strings.Fields(c.Scopes)
+}
+
+// GetClient gets the client with the provided id
+func GetClient(db *database.DB, id string) (Client, error) {
+	query := `SELECT id, secret, scopes FROM oauth_clients WHERE id = ?`
+
+	target := []Client{}
+
+	err := db.Select(&target, query, id)
+	if err != nil {
+		return Client{}, err
+	}
+
+	if len(target) == 0 {
+		return Client{}, sql.ErrNoRows
+	}
+
+	return target[0], nil
+}
+
+// Authenticate returns the client identified by the credentials on the request.
+// Credentials are read from the Authorization header (client_secret_basic) or the
+// client_id and client_secret form values (client_secret_post).
+// It returns ErrInvalidClient if the credentials are missing or don't match.
+func Authenticate(db *database.DB, r *http.Request) (Client, error) {
+	id, secret := Credentials(r)
+	if id == "" || secret == "" {
+		return Client{}, ErrInvalidClient
+	}
+
+	c, err := GetClient(db, id)
+	if err == sql.ErrNoRows {
+		return Client{}, ErrInvalidClient
+	}
+	if err != nil {
+		return Client{}, errors.Wrap(err, "get client")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(c.Secret), []byte(HashSecret(secret))) != 1 {
+		return Client{}, ErrInvalidClient
+	}
+
+	return c, nil
+}
+
+// Credentials returns the client ID and secret on the request, from the Authorization header (client_secret_basic)
+// or the client_id and client_secret form values (client_secret_post). They are empty if the request has none.
+func Credentials(r *http.Request) (id, secret string) {
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		id = r.PostFormValue("client_id")
+		secret = r.PostFormValue("client_secret")
+	}
+
+	return id, secret
+}
+
+// NewSecret returns a new random client secret along with the digest to store as the client's Secret.
+func NewSecret() (secret, hash string, err error) {
+	b := make([]byte, secretBytes)
+	_, err = rand.Read(b)
+	if err != nil {
+		return "", "", errors.Wrap(err, "new client secret")
+	}
+
+	secret = base64.RawURLEncoding.EncodeToString(b)
+	return secret, HashSecret(secret), nil
+}
+
+// HashSecret returns the digest a client secret is stored as.
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// GrantScopes returns the scopes the client should be granted for the space-delimited requested scopes.
+// If no scopes are requested, every allowed scope is granted.
+// It returns false if any of the requested scopes aren't allowed.
+func (c Client) GrantScopes(requested string) ([]string, bool) {
+	allowed := c.AllowedScopes()
+	if strings.TrimSpace(requested) == "" {
+		return allowed, true
+	}
+
+	granted := []string{}
+	for _, s := range strings.Fields(requested) {
+		ok := false
+		for _, a := range allowed {
+			if s == a {
+				ok = true
+				break
+			}
+		}
+
+		if !ok {
+			return nil, false
+		}
+
+		granted = append(granted, s)
+	}
+
+	return granted, true
+}
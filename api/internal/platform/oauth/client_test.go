@@ -0,0 +1,98 @@
+package oauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/jongschneider/youtube-project/api/internal/platform/database"
+	_ "modernc.org/sqlite" // provides the pure go sqlite driver for sqlx
+)
+
+// newTestDB returns an in-memory database with the oauth_clients table, holding a client with the secret.
+func newTestDB(t *testing.T, id, secret string) *database.DB {
+	t.Helper()
+
+	db, err := database.Open(database.SQLite, ":memory:", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	// Every connection to :memory: is a new database
+	db.SetMaxOpenConns(1)
+
+	_, err = db.DB.Exec(`CREATE TABLE oauth_clients (id VARCHAR(100) PRIMARY KEY, secret VARCHAR(100) NOT NULL, scopes VARCHAR(255) NOT NULL)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.DB.Exec(`INSERT INTO oauth_clients (id, secret, scopes) VALUES (?, ?, ?)`, id, secret, "users:read")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func postForm(v url.Values) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(v.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+func TestAuthenticate(t *testing.T) {
+	secret, hash, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := newTestDB(t, "reporting", hash)
+
+	basic := postForm(url.Values{})
+	basic.SetBasicAuth("reporting", secret)
+
+	tests := []struct {
+		name string
+		r    *http.Request
+		ok   bool
+	}{
+		{"basic", basic, true},
+		{"post", postForm(url.Values{"client_id": {"reporting"}, "client_secret": {secret}}), true},
+		{"wrong secret", postForm(url.Values{"client_id": {"reporting"}, "client_secret": {secret + "x"}}), false},
+		{"digest as secret", postForm(url.Values{"client_id": {"reporting"}, "client_secret": {hash}}), false},
+		{"unknown client", postForm(url.Values{"client_id": {"nobody"}, "client_secret": {secret}}), false},
+		{"no secret", postForm(url.Values{"client_id": {"reporting"}}), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Authenticate(db, tt.r)
+			if !tt.ok {
+				if err != ErrInvalidClient {
+					t.Errorf("err = %v, want %v", err, ErrInvalidClient)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.ID != "reporting" {
+				t.Errorf("client = %q, want %q", c.ID, "reporting")
+			}
+		})
+	}
+}
+
+func TestAuthenticateLegacyHash(t *testing.T) {
+	// Secrets stored before they were digested can't be checked without a slow hash, so they never authenticate
+	db := newTestDB(t, "legacy", "$argon2id$v=19$m=65536,t=1,p=2$c2FsdA$aGFzaA")
+
+	_, err := Authenticate(db, postForm(url.Values{"client_id": {"legacy"}, "client_secret": {"secret"}}))
+	if err != ErrInvalidClient {
+		t.Errorf("err = %v, want %v", err, ErrInvalidClient)
+	}
+}
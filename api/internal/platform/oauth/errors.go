@@ -0,0 +1,45 @@
+package oauth
+
+import (
+	"net/http"
+
+	"github.com/go-chi/render"
+)
+
+// Error codes defined by RFC 6749 section 5.2.
+const (
+	ErrorInvalidRequest       = "invalid_request"
+	ErrorInvalidClient        = "invalid_client"
+	ErrorInvalidGrant         = "invalid_grant"
+	ErrorUnauthorizedClient   = "unauthorized_client"
+	ErrorUnsupportedGrantType = "unsupported_grant_type"
+	ErrorInvalidScope         = "invalid_scope"
+	ErrorServerError          = "server_error"
+)
+
+type errResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// RespondWithError responds with an RFC 6749 error response.
+func RespondWithError(w http.ResponseWriter, r *http.Request, httpStatus int, code, description string) {
+	NoStore(w)
+
+	if code == ErrorInvalidClient && httpStatus == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(httpStatus)
+	render.JSON(w, r, errResponse{
+		Error:            code,
+		ErrorDescription: description,
+	})
+}
+
+// NoStore sets the headers that prevent token responses from being cached.
+func NoStore(w http.ResponseWriter) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+}
@@ -0,0 +1,93 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// prefixLen is the number of hex characters of a hash used to pick its range, as in the Pwned Passwords range API.
+const prefixLen = 5
+
+// Corpus is a set of breached passwords, stored as the SHA-1 hashes of the passwords.
+// Hashes are grouped into ranges by their first five hex characters, the same k-anonymity
+// layout used by the Pwned Passwords range API, so a lookup only ever looks at one range.
+type Corpus struct {
+	ranges map[string]map[string]struct{}
+}
+
+// LoadCorpus loads a corpus from a file with one uppercase or lowercase hex SHA-1 hash per line,
+// optionally followed by a colon and a count, like the Pwned Passwords downloads:
+//
+//	5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:3861493
+//
+// Blank lines and lines starting with # are ignored.
+func LoadCorpus(path string) (*Corpus, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c := &Corpus{ranges: map[string]map[string]struct{}{}}
+
+	line := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line++
+
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		if i := strings.IndexByte(text, ':'); i >= 0 {
+			text = text[:i]
+		}
+
+		hash := strings.ToUpper(text)
+		if len(hash) != sha1.Size*2 {
+			return nil, errors.Errorf("line %d: not a sha-1 hash", line)
+		}
+
+		_, err = hex.DecodeString(hash)
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %d", line)
+		}
+
+		c.add(hash)
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// add adds an uppercase hex SHA-1 hash to its range.
+func (c *Corpus) add(hash string) {
+	prefix, suffix := hash[:prefixLen], hash[prefixLen:]
+
+	r, ok := c.ranges[prefix]
+	if !ok {
+		r = map[string]struct{}{}
+		c.ranges[prefix] = r
+	}
+
+	r[suffix] = struct{}{}
+}
+
+// Contains returns true if the password is in the corpus.
+func (c *Corpus) Contains(pass string) bool {
+	sum := sha1.Sum([]byte(pass))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	_, ok := c.ranges[hash[:prefixLen]][hash[prefixLen:]]
+	return ok
+}
@@ -0,0 +1,180 @@
+package password
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// Rules a password can break.
+const (
+	RuleMinLength     = "min_length"
+	RuleMaxLength     = "max_length"
+	RuleMaxBytes      = "max_bytes"
+	RuleLowercase     = "lowercase"
+	RuleUppercase     = "uppercase"
+	RuleDigit         = "digit"
+	RuleSymbol        = "symbol"
+	RuleContainsEmail = "contains_email"
+	RuleBreached      = "breached"
+)
+
+// Config holds the configuration for a password Policy.
+type Config struct {
+	// MinLength and MaxLength are the number of characters a password must have.
+	MinLength int `envconfig:"PASSWORD_MIN_LENGTH" default:"10"`
+	MaxLength int `envconfig:"PASSWORD_MAX_LENGTH" default:"128"`
+
+	// MaxBytes is the most bytes a password can have, because the hash algorithm can't use more. 0 means no limit.
+	// It isn't configured on its own; set it from the password hasher.
+	MaxBytes int `ignored:"true"`
+
+	// Each of these requires the password to have at least one character of that class.
+	RequireLowercase bool `envconfig:"PASSWORD_REQUIRE_LOWERCASE" default:"true"`
+	RequireUppercase bool `envconfig:"PASSWORD_REQUIRE_UPPERCASE" default:"true"`
+	RequireDigit     bool `envconfig:"PASSWORD_REQUIRE_DIGIT" default:"true"`
+	RequireSymbol    bool `envconfig:"PASSWORD_REQUIRE_SYMBOL" default:"false"`
+
+	// AllowEmail should be true if a password may contain the user's email or the part of it before the @.
+	AllowEmail bool `envconfig:"PASSWORD_ALLOW_EMAIL" default:"false"`
+
+	// BreachedFile is the path of a corpus of breached passwords that can't be used. See LoadCorpus for its format.
+	BreachedFile string `envconfig:"PASSWORD_BREACHED_FILE"`
+}
+
+// DefaultConfig returns the configuration a Config is given by envconfig when nothing is set.
+func DefaultConfig() Config {
+	return Config{
+		MinLength:        10,
+		MaxLength:        128,
+		RequireLowercase: true,
+		RequireUppercase: true,
+		RequireDigit:     true,
+	}
+}
+
+// Violation is a rule a password breaks.
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// ValidationError is the error returned when a password breaks one or more rules of the Policy.
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	rules := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		rules = append(rules, v.Rule)
+	}
+
+	return fmt.Sprintf("password breaks rules: %s", strings.Join(rules, ", "))
+}
+
+// Policy decides which passwords can be used.
+type Policy struct {
+	cfg      Config
+	breached *Corpus
+}
+
+// New returns a Policy with the provided configuration, loading the breached password corpus if there is one.
+func New(c Config) (*Policy, error) {
+	p := &Policy{cfg: c}
+
+	if c.BreachedFile != "" {
+		corpus, err := LoadCorpus(c.BreachedFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "load breached passwords")
+		}
+		p.breached = corpus
+	}
+
+	return p, nil
+}
+
+// Validate returns a *ValidationError listing every rule the password breaks for the user with the provided email,
+// or nil if it can be used.
+func (p *Policy) Validate(pass, email string) error {
+	violations := []Violation{}
+	add := func(rule, format string, args ...interface{}) {
+		violations = append(violations, Violation{Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	length := utf8.RuneCountInString(pass)
+	if length < p.cfg.MinLength {
+		add(RuleMinLength, "must be at least %d characters", p.cfg.MinLength)
+	}
+	if p.cfg.MaxLength > 0 && length > p.cfg.MaxLength {
+		add(RuleMaxLength, "must be at most %d characters", p.cfg.MaxLength)
+	}
+	if p.cfg.MaxBytes > 0 && len(pass) > p.cfg.MaxBytes {
+		add(RuleMaxBytes, "must be at most %d bytes", p.cfg.MaxBytes)
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range pass {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+
+	if p.cfg.RequireLowercase && !lower {
+		add(RuleLowercase, "must contain a lowercase letter")
+	}
+	if p.cfg.RequireUppercase && !upper {
+		add(RuleUppercase, "must contain an uppercase letter")
+	}
+	if p.cfg.RequireDigit && !digit {
+		add(RuleDigit, "must contain a digit")
+	}
+	if p.cfg.RequireSymbol && !symbol {
+		add(RuleSymbol, "must contain a symbol")
+	}
+
+	if !p.cfg.AllowEmail && containsEmail(pass, email) {
+		add(RuleContainsEmail, "must not contain your email")
+	}
+
+	if p.breached != nil && p.breached.Contains(pass) {
+		add(RuleBreached, "has appeared in a data breach")
+	}
+
+	if len(violations) > 0 {
+		return &ValidationError{Violations: violations}
+	}
+
+	return nil
+}
+
+// containsEmail returns true if the password contains the email or its local part, ignoring case.
+func containsEmail(pass, email string) bool {
+	pass = strings.ToLower(pass)
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return false
+	}
+
+	if strings.Contains(pass, email) {
+		return true
+	}
+
+	// Very short local parts would match too many passwords by chance
+	local := email
+	if i := strings.LastIndex(email, "@"); i >= 0 {
+		local = email[:i]
+	}
+
+	return len(local) >= 3 && strings.Contains(pass, local)
+}
@@ -0,0 +1,70 @@
+package password
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+func TestDefaultConfigMatchesEnvconfig(t *testing.T) {
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, "PASSWORD_") {
+			t.Skipf("%s is set", kv)
+		}
+	}
+
+	var c Config
+	err := envconfig.Process("", &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c != DefaultConfig() {
+		t.Errorf("DefaultConfig() = %+v, want the envconfig defaults %+v", DefaultConfig(), c)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	p, err := New(DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		pass  string
+		rules []string
+	}{
+		{"Correct-Horse-1", nil},
+		{"", []string{RuleMinLength, RuleLowercase, RuleUppercase, RuleDigit}},
+		{"Short-1", []string{RuleMinLength}},
+		{"all-lowercase-1", []string{RuleUppercase}},
+		{"Jane-Doe-Horse-1", []string{RuleContainsEmail}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pass, func(t *testing.T) {
+			err := p.Validate(tt.pass, "jane-doe@example.com")
+			if tt.rules == nil {
+				if err != nil {
+					t.Fatalf("err = %v, want nil", err)
+				}
+				return
+			}
+
+			verr, ok := err.(*ValidationError)
+			if !ok {
+				t.Fatalf("err = %v, want a *ValidationError", err)
+			}
+
+			rules := []string{}
+			for _, v := range verr.Violations {
+				rules = append(rules, v.Rule)
+			}
+			if strings.Join(rules, ",") != strings.Join(tt.rules, ",") {
+				t.Errorf("rules = %v, want %v", rules, tt.rules)
+			}
+		})
+	}
+}
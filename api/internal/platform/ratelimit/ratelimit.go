@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+// ErrLimited is the error returned when a key has been used more times than the limit allows within the window.
+var ErrLimited = errors.New("rate limited")
+
+// allowScript counts a use of a key, starting its window on the first use.
+// It returns 0 if the use is allowed, otherwise how many milliseconds are left in the window.
+var allowScript = redis.NewScript(`
+local n = redis.call('INCR', KEYS[1])
+if n == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+
+if n > tonumber(ARGV[2]) then
+	local wait = redis.call('PTTL', KEYS[1])
+	if wait <= 0 then
+		return 1
+	end
+	return wait
+end
+
+return 0
+`)
+
+// Limiter allows each key a number of uses in a fixed window, counted in the cache so every replica shares the limit.
+type Limiter struct {
+	cache     *redis.Client
+	keyPrefix string
+	limit     int
+	window    time.Duration
+}
+
+// New returns a Limiter that allows each key limit uses per window. Limiters with different names count separately.
+// A limit of 0 allows everything.
+func New(c *redis.Client, name string, limit int, window time.Duration) *Limiter {
+	return &Limiter{
+		cache:     c,
+		keyPrefix: fmt.Sprintf("ratelimit:%s:", name),
+		limit:     limit,
+		window:    window,
+	}
+}
+
+// Allow records a use of the key. It returns ErrLimited, along with how long until the key can be used again,
+// if the key has been used up for the window.
+func (l *Limiter) Allow(key string) (time.Duration, error) {
+	if l.limit <= 0 {
+		return 0, nil
+	}
+
+	wait, err := allowScript.Run(l.cache, []string{l.keyPrefix + key}, int64(l.window/time.Millisecond), l.limit).Int64()
+	if err != nil {
+		return 0, errors.Wrap(err, "rate limit")
+	}
+
+	if wait > 0 {
+		return time.Duration(wait) * time.Millisecond, ErrLimited
+	}
+
+	return 0, nil
+}
@@ -0,0 +1,63 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+)
+
+func TestAllow(t *testing.T) {
+	s := miniredis.RunT(t)
+	c := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	defer c.Close()
+
+	l := New(c, "test", 2, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		_, err := l.Allow("a")
+		if err != nil {
+			t.Fatalf("use %d: %v", i+1, err)
+		}
+	}
+
+	wait, err := l.Allow("a")
+	if err != ErrLimited {
+		t.Fatalf("err = %v, want %v", err, ErrLimited)
+	}
+	if wait <= 0 || wait > time.Minute {
+		t.Errorf("wait = %s, want up to 1m", wait)
+	}
+
+	// Keys and limiters count separately
+	_, err = l.Allow("b")
+	if err != nil {
+		t.Errorf("other key: %v", err)
+	}
+
+	_, err = New(c, "other", 2, time.Minute).Allow("a")
+	if err != nil {
+		t.Errorf("other limiter: %v", err)
+	}
+
+	// A new window starts once the old one is over
+	s.FastForward(time.Minute)
+
+	_, err = l.Allow("a")
+	if err != nil {
+		t.Errorf("next window: %v", err)
+	}
+}
+
+func TestAllowWithoutLimit(t *testing.T) {
+	// Without a limit the cache is never used, so there doesn't have to be one
+	l := New(nil, "test", 0, time.Minute)
+
+	for i := 0; i < 10; i++ {
+		_, err := l.Allow("a")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// These are the parameters every authenticator app supports, so they aren't configurable.
+const (
+	digits = 6
+	period = 30 * time.Second
+
+	// skew is how many periods before and after the current one are accepted to allow for clock drift.
+	skew = 1
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random base32-encoded secret.
+func GenerateSecret() (string, error) {
+	b := make([]byte, 20)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return encoding.EncodeToString(b), nil
+}
+
+// URI returns the otpauth:// URI authenticator apps use to enroll the secret.
+func URI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(digits))
+	v.Set("period", fmt.Sprint(int(period/time.Second)))
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return fmt.Sprintf("otpauth://totp/%s?%s", label, v.Encode())
+}
+
+// QRCode returns a PNG of the QR code encoding the otpauth:// URI.
+func QRCode(uri string, size int) ([]byte, error) {
+	png, err := qrcode.Encode(uri, qrcode.Medium, size)
+	if err != nil {
+		return nil, errors.Wrap(err, "encode qr code")
+	}
+
+	return png, nil
+}
+
+// Code returns the code for the secret at the provided time as defined in RFC 6238.
+func Code(secret string, t time.Time) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", errors.Wrap(err, "decode secret")
+	}
+
+	return code(key, uint64(t.Unix()/int64(period/time.Second))), nil
+}
+
+// Validate returns true if the code is valid for the secret at the provided time.
+func Validate(secret, passcode string, t time.Time) bool {
+	_, ok := Match(secret, passcode, t)
+	return ok
+}
+
+// Match returns the counter of the period the code is valid for, and true if it is valid for the secret at the provided time.
+// Codes are accepted for a while, so callers must remember the counter and refuse codes at or before it to prevent replays.
+func Match(secret, passcode string, t time.Time) (int64, bool) {
+	passcode = strings.TrimSpace(passcode)
+	if len(passcode) != digits {
+		return 0, false
+	}
+
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false
+	}
+
+	counter := t.Unix() / int64(period/time.Second)
+
+	// Every period is checked so the time taken doesn't depend on which one matched
+	matched := int64(-1)
+	for i := -skew; i <= skew; i++ {
+		c := code(key, uint64(counter+int64(i)))
+		if subtle.ConstantTimeCompare([]byte(c), []byte(passcode)) == 1 {
+			matched = counter + int64(i)
+		}
+	}
+
+	return matched, matched >= 0
+}
+
+// code implements the HOTP algorithm from RFC 4226.
+func code(key []byte, counter uint64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", digits, value%1000000)
+}
@@ -0,0 +1,269 @@
+package user
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jongschneider/youtube-project/api/internal/platform/database"
+	"github.com/pkg/errors"
+)
+
+// Limits on the number of users in a page.
+const (
+	DefaultLimit = 50
+	MaxLimit     = 200
+)
+
+var (
+	// ErrInvalidCursor is the error returned when a cursor can't be decoded or was made for a different sort.
+	ErrInvalidCursor = errors.New("invalid cursor")
+
+	// ErrInvalidSort is the error returned when users can't be sorted by the requested field.
+	ErrInvalidSort = errors.New("invalid sort")
+)
+
+// sortColumns maps each field users can be sorted by to its column.
+var sortColumns = map[string]string{
+	"id":         "id",
+	"email":      "email",
+	"created_at": "created_at",
+}
+
+// ListOptions selects a page of users.
+type ListOptions struct {
+	// Limit is the most users in the page. It defaults to DefaultLimit and can't be more than MaxLimit.
+	Limit int
+
+	// After is the cursor of the previous page. The page starts with the user after it.
+	After string
+
+	// EmailPrefix only selects users whose email starts with it, ignoring case.
+	EmailPrefix string
+
+	// CreatedAfter and CreatedBefore only select users created within them. Zero times are ignored.
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+
+	// Sort is the field users are sorted by: id, email or created_at. A leading - sorts in descending order.
+	// Users are sorted by id by default, and ties are always broken by id.
+	Sort string
+
+	// Count should be true if the page should include the total number of users matching the filters.
+	Count bool
+}
+
+// Page is a page of users.
+type Page struct {
+	Users []User
+
+	// Next is the cursor of the next page. It is empty if this is the last page.
+	Next string
+
+	// Total is the number of users matching the filters, if it was asked for.
+	Total *int
+}
+
+// cursor is the position of the last user of a page in the sort order.
+type cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v,omitempty"`
+	ID    int    `json:"id"`
+}
+
+func encodeCursor(c cursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+
+	c := cursor{}
+	err = json.Unmarshal(b, &c)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+
+	return c, nil
+}
+
+// sortValue returns the value of the sort column for the user, as stored in a cursor.
+func sortValue(u User, column string) string {
+	switch column {
+	case "email":
+		return u.Email
+	case "created_at":
+		return u.CreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+
+	return ""
+}
+
+// listQuery is a validated ListOptions, with the defaults filled in and its cursor decoded.
+type listQuery struct {
+	ListOptions
+
+	column string
+	desc   bool
+
+	// after is the last user of the previous page, with only the id and sort column set. It is nil on the first page.
+	after *User
+}
+
+func newListQuery(o ListOptions) (listQuery, error) {
+	if o.Limit <= 0 {
+		o.Limit = DefaultLimit
+	}
+	if o.Limit > MaxLimit {
+		o.Limit = MaxLimit
+	}
+
+	if o.Sort == "" {
+		o.Sort = "id"
+	}
+
+	q := listQuery{
+		ListOptions: o,
+		desc:        strings.HasPrefix(o.Sort, "-"),
+	}
+
+	var ok bool
+	q.column, ok = sortColumns[strings.TrimPrefix(o.Sort, "-")]
+	if !ok {
+		return listQuery{}, ErrInvalidSort
+	}
+
+	if o.After == "" {
+		return q, nil
+	}
+
+	c, err := decodeCursor(o.After)
+	if err != nil {
+		return listQuery{}, err
+	}
+
+	if c.Sort != o.Sort {
+		return listQuery{}, ErrInvalidCursor
+	}
+
+	q.after = &User{ID: c.ID}
+	switch q.column {
+	case "email":
+		q.after.Email = c.Value
+	case "created_at":
+		q.after.CreatedAt, err = time.Parse(time.RFC3339Nano, c.Value)
+		if err != nil {
+			return listQuery{}, ErrInvalidCursor
+		}
+	}
+
+	return q, nil
+}
+
+// page returns the page made of the users, which are the matching users in order with one extra to tell if there is another page.
+func (q listQuery) page(users []User, total *int) Page {
+	page := Page{Users: users, Total: total}
+
+	if len(page.Users) > q.Limit {
+		page.Users = page.Users[:q.Limit]
+
+		last := page.Users[len(page.Users)-1]
+		page.Next = encodeCursor(cursor{
+			Sort:  q.Sort,
+			Value: sortValue(last, q.column),
+			ID:    last.ID,
+		})
+	}
+
+	return page
+}
+
+// List gets a page of users matching the options.
+func List(ctx context.Context, db *database.DB, o ListOptions) (Page, error) {
+	q, err := newListQuery(o)
+	if err != nil {
+		return Page{}, err
+	}
+
+	where := []string{"deleted_at IS NULL"}
+	args := []interface{}{}
+
+	if q.EmailPrefix != "" {
+		where = append(where, "LOWER(email) LIKE LOWER(?) ESCAPE '!'")
+		args = append(args, escapeLike(q.EmailPrefix)+"%")
+	}
+	if !q.CreatedAfter.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, q.CreatedAfter.UTC())
+	}
+	if !q.CreatedBefore.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, q.CreatedBefore.UTC())
+	}
+
+	var total *int
+	if q.Count {
+		n := 0
+		err := db.GetContext(ctx, &n, `SELECT COUNT(*) FROM users`+whereClause(where), args...)
+		if err != nil {
+			return Page{}, errors.Wrap(err, "count users")
+		}
+		total = &n
+	}
+
+	op, dir := ">", "ASC"
+	if q.desc {
+		op, dir = "<", "DESC"
+	}
+
+	if q.after != nil {
+		// Keyset pagination: continue after the last user of the previous page, breaking ties by id
+		switch q.column {
+		case "id":
+			where = append(where, fmt.Sprintf("id %s ?", op))
+			args = append(args, q.after.ID)
+		case "created_at":
+			where = append(where, fmt.Sprintf("(created_at %[1]s ? OR (created_at = ? AND id %[1]s ?))", op))
+			args = append(args, q.after.CreatedAt, q.after.CreatedAt, q.after.ID)
+		default:
+			where = append(where, fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", q.column, op))
+			args = append(args, q.after.Email, q.after.Email, q.after.ID)
+		}
+	}
+
+	query := `SELECT id, password, email, role, totp_secret, totp_enabled, email_verified_at, version, created_at FROM users` +
+		whereClause(where) +
+		fmt.Sprintf(" ORDER BY %s %s", q.column, dir)
+	if q.column != "id" {
+		query += fmt.Sprintf(", id %s", dir)
+	}
+	query += " LIMIT ?"
+
+	// Get one extra user to find out if there is another page
+	args = append(args, q.Limit+1)
+
+	users := []User{}
+	err = db.SelectContext(ctx, &users, query, args...)
+	if err != nil {
+		return Page{}, err
+	}
+
+	return q.page(users, total), nil
+}
+
+func whereClause(where []string) string {
+	return " WHERE " + strings.Join(where, " AND ")
+}
+
+// escapeLike escapes the characters that have a special meaning in a LIKE pattern.
+// ! is used as the escape character because, unlike \, it means the same thing in every dialect.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`!`, `!!`, `%`, `!%`, `_`, `!_`).Replace(s)
+}
@@ -0,0 +1,206 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jongschneider/youtube-project/api/internal/platform/encryption"
+)
+
+func TestMain(m *testing.M) {
+	// Cheap hashes keep the tests fast
+	err := encryption.Configure(encryption.Config{Algorithm: encryption.Bcrypt, BcryptCost: 4})
+	if err != nil {
+		panic(err)
+	}
+
+	err = ConfigureRecoveryCodes("test-recovery-code-key-0123456789")
+	if err != nil {
+		panic(err)
+	}
+
+	os.Exit(m.Run())
+}
+
+// testStores returns every kind of Store, empty, by name.
+func testStores(t *testing.T) map[string]Store {
+	t.Helper()
+
+	sqlite, err := NewSQLiteStore(":memory:", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlite.Close() })
+
+	return map[string]Store{
+		"memory": NewMemoryStore(),
+		"sqlite": sqlite,
+	}
+}
+
+// insertUsers adds a user for each email and returns their ids in the same order.
+func insertUsers(t *testing.T, s Store, emails ...string) []int {
+	t.Helper()
+
+	ids := []int{}
+	for _, email := range emails {
+		id, err := s.Insert(context.Background(), User{Email: email, Password: "Correct-Horse-1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+// listAll follows the cursors from the first page to the last and returns the emails in the order they were listed.
+func listAll(t *testing.T, s Store, o ListOptions) []string {
+	t.Helper()
+
+	emails := []string{}
+	for i := 0; ; i++ {
+		if i > 100 {
+			t.Fatal("pages never end")
+		}
+
+		page, err := s.List(context.Background(), o)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(page.Users) > o.Limit {
+			t.Fatalf("page has %d users, want at most %d", len(page.Users), o.Limit)
+		}
+
+		for _, u := range page.Users {
+			emails = append(emails, u.Email)
+		}
+
+		if page.Next == "" {
+			return emails
+		}
+		o.After = page.Next
+	}
+}
+
+func TestListPaginates(t *testing.T) {
+	// Users created within the same instant need ties broken by id
+	emails := []string{"c@example.com", "a@example.com", "e@example.com", "b@example.com", "d@example.com", "bb@example.com", "f@example.com"}
+
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ids := insertUsers(t, s, emails...)
+
+			byEmail := append([]string{}, emails...)
+			sort.Strings(byEmail)
+			byEmailDesc := append([]string{}, byEmail...)
+			sort.Sort(sort.Reverse(sort.StringSlice(byEmailDesc)))
+
+			tests := []struct {
+				sort string
+				want []string
+			}{
+				{"", emails},
+				{"id", emails},
+				{"created_at", emails},
+				{"email", byEmail},
+				{"-email", byEmailDesc},
+			}
+
+			for _, tt := range tests {
+				for _, limit := range []int{1, 2, 3, len(emails), len(emails) + 1} {
+					got := listAll(t, s, ListOptions{Limit: limit, Sort: tt.sort})
+					if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+						t.Errorf("sort %q, limit %d: listed %v, want %v", tt.sort, limit, got, tt.want)
+					}
+				}
+			}
+
+			// Newest first
+			got := listAll(t, s, ListOptions{Limit: 2, Sort: "-id"})
+			if len(got) != len(ids) || got[0] != emails[len(emails)-1] {
+				t.Errorf("sort -id: listed %v", got)
+			}
+		})
+	}
+}
+
+func TestListSkipsDeletedUsers(t *testing.T) {
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ids := insertUsers(t, s, "a@example.com", "b@example.com", "c@example.com")
+
+			err := s.Delete(context.Background(), ids[1], 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := listAll(t, s, ListOptions{Limit: 1})
+			if strings.Join(got, ",") != "a@example.com,c@example.com" {
+				t.Errorf("listed %v, want the users that weren't deleted", got)
+			}
+		})
+	}
+}
+
+func TestListFiltersAndCounts(t *testing.T) {
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			insertUsers(t, s, "Jane@example.com", "jane.doe@example.com", "john@example.com", "jan_e@example.com")
+
+			page, err := s.List(context.Background(), ListOptions{EmailPrefix: "JANE", Count: true, Limit: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if page.Total == nil || *page.Total != 2 {
+				t.Errorf("total = %v, want 2", page.Total)
+			}
+			if len(page.Users) != 1 || page.Next == "" {
+				t.Errorf("got %d users and next %q, want 1 user and a next page", len(page.Users), page.Next)
+			}
+
+			// _ only matches itself
+			got := listAll(t, s, ListOptions{EmailPrefix: "jan_", Limit: 10})
+			if strings.Join(got, ",") != "jan_e@example.com" {
+				t.Errorf("listed %v, want only jan_e@example.com", got)
+			}
+		})
+	}
+}
+
+func TestListInvalidOptions(t *testing.T) {
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			insertUsers(t, s, "a@example.com", "b@example.com")
+
+			page, err := s.List(context.Background(), ListOptions{Limit: 1, Sort: "email"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tests := []struct {
+				o    ListOptions
+				want error
+			}{
+				{ListOptions{Sort: "password"}, ErrInvalidSort},
+				{ListOptions{After: "not a cursor"}, ErrInvalidCursor},
+				// A cursor only continues the sort it was made for
+				{ListOptions{After: page.Next, Sort: "-email"}, ErrInvalidCursor},
+			}
+
+			for _, tt := range tests {
+				_, err := s.List(context.Background(), tt.o)
+				if err != tt.want {
+					t.Errorf("%s: err = %v, want %v", fmt.Sprintf("%+v", tt.o), err, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,589 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jongschneider/youtube-project/api/internal/platform/database"
+	"github.com/jongschneider/youtube-project/api/internal/platform/encryption"
+	"github.com/pkg/errors"
+)
+
+// MemoryStore is a Store that keeps users in memory. It is safe for concurrent use.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	users   map[int]User
+	deleted map[int]time.Time
+	lastID  int
+
+	// totpCounters is the counter of the last TOTP code each user logged in with.
+	totpCounters map[int]int64
+
+	// recoveryCodes maps each user to the hashes of their recovery codes and whether each one has been used.
+	recoveryCodes map[int]map[string]bool
+
+	// resets maps the hashes of password reset tokens to their resets.
+	resets map[string]memoryReset
+}
+
+// memoryReset is a password reset kept by a MemoryStore.
+type memoryReset struct {
+	userID    int
+	expiresAt time.Time
+	used      bool
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:         map[int]User{},
+		deleted:       map[int]time.Time{},
+		totpCounters:  map[int]int64{},
+		recoveryCodes: map[int]map[string]bool{},
+		resets:        map[string]memoryReset{},
+	}
+}
+
+// GetByEmail gets the user with the email.
+func (s *MemoryStore) GetByEmail(ctx context.Context, email string) (User, error) {
+	if err := contextError(ctx); err != nil {
+		return User{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for id, u := range s.users {
+		if _, ok := s.deleted[id]; !ok && u.Email == email {
+			return u, nil
+		}
+	}
+
+	return User{}, sql.ErrNoRows
+}
+
+// GetByID gets the user with the id.
+func (s *MemoryStore) GetByID(ctx context.Context, id int) (User, error) {
+	if err := contextError(ctx); err != nil {
+		return User{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.get(id)
+}
+
+// get gets the user with the id. s.mu must be held.
+func (s *MemoryStore) get(id int) (User, error) {
+	u, ok := s.users[id]
+	if !ok {
+		return User{}, sql.ErrNoRows
+	}
+
+	if _, ok := s.deleted[id]; ok {
+		return User{}, sql.ErrNoRows
+	}
+
+	return u, nil
+}
+
+// List gets a page of users matching the options.
+func (s *MemoryStore) List(ctx context.Context, o ListOptions) (Page, error) {
+	if err := contextError(ctx); err != nil {
+		return Page{}, err
+	}
+
+	q, err := newListQuery(o)
+	if err != nil {
+		return Page{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	matching := []User{}
+	for id, u := range s.users {
+		if _, ok := s.deleted[id]; ok {
+			continue
+		}
+
+		// The prefix matches ignoring case, like the LOWER(email) LIKE LOWER(?) of the SQL stores
+		if q.EmailPrefix != "" && !strings.HasPrefix(strings.ToLower(u.Email), strings.ToLower(q.EmailPrefix)) {
+			continue
+		}
+		if !q.CreatedAfter.IsZero() && u.CreatedAt.Before(q.CreatedAfter) {
+			continue
+		}
+		if !q.CreatedBefore.IsZero() && !u.CreatedAt.Before(q.CreatedBefore) {
+			continue
+		}
+
+		matching = append(matching, u)
+	}
+
+	var total *int
+	if q.Count {
+		n := len(matching)
+		total = &n
+	}
+
+	// inOrder returns true if a comes before b in the sort order
+	inOrder := func(a, b User) bool {
+		c := compareUsers(a, b, q.column)
+		if q.desc {
+			return c > 0
+		}
+		return c < 0
+	}
+
+	users := []User{}
+	for _, u := range matching {
+		if q.after == nil || inOrder(*q.after, u) {
+			users = append(users, u)
+		}
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return inOrder(users[i], users[j])
+	})
+
+	// Keep one extra user to find out if there is another page
+	if len(users) > q.Limit+1 {
+		users = users[:q.Limit+1]
+	}
+
+	return q.page(users, total), nil
+}
+
+// compareUsers compares the users by the column, breaking ties by id.
+// It returns a negative number if a comes first, a positive number if b comes first and 0 if they are the same user.
+func compareUsers(a, b User, column string) int {
+	switch column {
+	case "email":
+		if c := strings.Compare(a.Email, b.Email); c != 0 {
+			return c
+		}
+	case "created_at":
+		if a.CreatedAt.Before(b.CreatedAt) {
+			return -1
+		}
+		if a.CreatedAt.After(b.CreatedAt) {
+			return 1
+		}
+	}
+
+	return a.ID - b.ID
+}
+
+// Insert creates a new user from its email and password, and returns its ID.
+func (s *MemoryStore) Insert(ctx context.Context, u User) (int, error) {
+	hash, err := encryption.Encrypt(u.Password)
+	if err != nil {
+		return 0, errors.Wrap(err, "insert")
+	}
+
+	// Hashing is slow, so the context could have ended while doing it
+	if err := contextError(ctx); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.emailInUse(u.Email, 0) {
+		return 0, ErrEmailInUse
+	}
+
+	s.lastID++
+	s.users[s.lastID] = User{
+		ID:        s.lastID,
+		Password:  hash,
+		Email:     u.Email,
+		Role:      RoleUser,
+		CreatedAt: time.Now().UTC(),
+		Version:   1,
+	}
+
+	return s.lastID, nil
+}
+
+// Update changes the fields of the user that are set in c.
+// Changing the email clears its verification, since the new address hasn't been verified.
+// If version isn't 0 the user is only updated if it is still at that version, otherwise ErrVersionMismatch is returned.
+func (s *MemoryStore) Update(ctx context.Context, id, version int, c Changes) error {
+	if c.Role != nil && !ValidRole(*c.Role) {
+		return ErrInvalidRole
+	}
+
+	// Nothing to change
+	if c.Email == nil && c.Password == nil && c.Role == nil {
+		return nil
+	}
+
+	hash := ""
+	if c.Password != nil {
+		var err error
+		hash, err = encryption.Encrypt(*c.Password)
+		if err != nil {
+			return errors.Wrap(err, "update")
+		}
+	}
+
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, err := s.get(id)
+	if err != nil {
+		return err
+	}
+
+	if version != 0 && u.Version != version {
+		return ErrVersionMismatch
+	}
+
+	if c.Email != nil {
+		if s.emailInUse(*c.Email, id) {
+			return ErrEmailInUse
+		}
+		if *c.Email != u.Email {
+			u.EmailVerifiedAt = nil
+		}
+		u.Email = *c.Email
+	}
+	if c.Password != nil {
+		u.Password = hash
+	}
+	if c.Role != nil {
+		u.Role = *c.Role
+	}
+	u.Version++
+
+	s.users[id] = u
+
+	return nil
+}
+
+// Delete soft deletes the user.
+// If version isn't 0 the user is only deleted if it is still at that version, otherwise ErrVersionMismatch is returned.
+func (s *MemoryStore) Delete(ctx context.Context, id, version int) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, err := s.get(id)
+	if err != nil {
+		return err
+	}
+
+	if version != 0 && u.Version != version {
+		return ErrVersionMismatch
+	}
+
+	u.Version++
+	s.users[id] = u
+	s.deleted[id] = time.Now().UTC()
+
+	return nil
+}
+
+// Restore brings back a soft deleted user that hasn't been purged yet.
+// It returns sql.ErrNoRows if there is no such user.
+func (s *MemoryStore) Restore(ctx context.Context, id int) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	if _, ok := s.deleted[id]; !ok {
+		return sql.ErrNoRows
+	}
+
+	if s.emailInUse(u.Email, id) {
+		return ErrEmailInUse
+	}
+
+	u.Version++
+	s.users[id] = u
+	delete(s.deleted, id)
+
+	return nil
+}
+
+// VerifyEmail marks the user's email as verified, as long as it is still the email the verification was sent to.
+// Verifying an email that is already verified keeps the original timestamp.
+func (s *MemoryStore) VerifyEmail(ctx context.Context, id int, email string) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, err := s.get(id)
+	if err != nil {
+		return err
+	}
+
+	if u.Email != email {
+		return ErrEmailChanged
+	}
+
+	if u.EmailVerifiedAt == nil {
+		now := time.Now().UTC()
+		u.EmailVerifiedAt = &now
+	}
+	u.Version++
+	s.users[id] = u
+
+	return nil
+}
+
+// SetTOTPSecret starts TOTP enrollment by storing a new secret for the user.
+// MFA stays disabled until EnableTOTP is called.
+func (s *MemoryStore) SetTOTPSecret(ctx context.Context, id int, secret string) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, err := s.get(id)
+	if err != nil {
+		return err
+	}
+
+	u.TOTPSecret = secret
+	u.TOTPEnabled = false
+	u.Version++
+	s.users[id] = u
+
+	return nil
+}
+
+// EnableTOTP finishes TOTP enrollment and replaces the user's recovery codes with new ones.
+// The plain text recovery codes are returned so they can be shown to the user once; only their hashes are kept.
+func (s *MemoryStore) EnableTOTP(ctx context.Context, id int) ([]string, error) {
+	codes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, err := s.get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	u.TOTPEnabled = true
+	u.Version++
+	s.users[id] = u
+
+	hashes := map[string]bool{}
+	for _, c := range codes {
+		hashes[HashRecoveryCode(c)] = false
+	}
+	s.recoveryCodes[id] = hashes
+
+	return codes, nil
+}
+
+// DisableTOTP turns MFA off for the user and removes their secret and recovery codes.
+func (s *MemoryStore) DisableTOTP(ctx context.Context, id int) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, err := s.get(id)
+	if err != nil {
+		return err
+	}
+
+	u.TOTPSecret = ""
+	u.TOTPEnabled = false
+	u.Version++
+	s.users[id] = u
+
+	delete(s.recoveryCodes, id)
+
+	return nil
+}
+
+// UseRecoveryCode marks one of the user's recovery codes as used.
+// It returns false if the code doesn't exist or has already been used.
+func (s *MemoryStore) UseRecoveryCode(ctx context.Context, id int, code string) (bool, error) {
+	if len(recoveryCodeKey) == 0 {
+		return false, ErrNoRecoveryCodeKey
+	}
+
+	if err := contextError(ctx); err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash := HashRecoveryCode(code)
+	used, ok := s.recoveryCodes[id][hash]
+	if !ok || used {
+		return false, nil
+	}
+
+	s.recoveryCodes[id][hash] = true
+
+	return true, nil
+}
+
+// UseTOTPCounter records that the user logged in with the TOTP code of the counter's period.
+// It returns false if a code from that period or a later one was already used, so every code can only be used once.
+func (s *MemoryStore) UseTOTPCounter(ctx context.Context, id int, counter int64) (bool, error) {
+	if err := contextError(ctx); err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.get(id); err != nil || s.totpCounters[id] >= counter {
+		return false, nil
+	}
+
+	s.totpCounters[id] = counter
+
+	return true, nil
+}
+
+// CreatePasswordReset creates a single-use password reset token for the user that expires after ttl.
+// Only a hash of the token is kept; the token itself is returned so it can be sent to the user.
+func (s *MemoryStore) CreatePasswordReset(ctx context.Context, id int, ttl time.Duration) (string, error) {
+	token, err := newResetToken()
+	if err != nil {
+		return "", errors.Wrap(err, "create password reset")
+	}
+
+	if err := contextError(ctx); err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.resets[hashResetToken(token)] = memoryReset{
+		userID:    id,
+		expiresAt: time.Now().UTC().Add(ttl),
+	}
+
+	return token, nil
+}
+
+// GetByResetToken gets the user an outstanding password reset token was issued to.
+// It returns ErrInvalidResetToken if the token is unknown, expired or already used.
+func (s *MemoryStore) GetByResetToken(ctx context.Context, token string) (User, error) {
+	if err := contextError(ctx); err != nil {
+		return User{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	reset, ok := s.reset(token)
+	if !ok {
+		return User{}, ErrInvalidResetToken
+	}
+
+	return s.get(reset.userID)
+}
+
+// ResetPassword sets a new password for the user the reset token was issued to and uses up the token,
+// along with every other outstanding reset token for the user.
+// It returns the user whose password was reset, ErrInvalidResetToken, or sql.ErrNoRows if the user has been deleted.
+func (s *MemoryStore) ResetPassword(ctx context.Context, token, password string) (User, error) {
+	hash, err := encryption.Encrypt(password)
+	if err != nil {
+		return User{}, errors.Wrap(err, "reset password")
+	}
+
+	if err := contextError(ctx); err != nil {
+		return User{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reset, ok := s.reset(token)
+	if !ok {
+		return User{}, ErrInvalidResetToken
+	}
+
+	u, err := s.get(reset.userID)
+	if err != nil {
+		return User{}, err
+	}
+
+	u.Password = hash
+	u.Version++
+	s.users[reset.userID] = u
+
+	for h, r := range s.resets {
+		if r.userID == reset.userID {
+			r.used = true
+			s.resets[h] = r
+		}
+	}
+
+	return s.get(reset.userID)
+}
+
+// emailInUse returns true if a user other than the one with the id, and that hasn't been deleted, has the email. s.mu must be held.
+func (s *MemoryStore) emailInUse(email string, id int) bool {
+	for other, u := range s.users {
+		if _, ok := s.deleted[other]; !ok && other != id && u.Email == email {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reset gets the outstanding password reset for the token. s.mu must be held.
+func (s *MemoryStore) reset(token string) (memoryReset, bool) {
+	r, ok := s.resets[hashResetToken(token)]
+	if !ok || r.used || !r.expiresAt.After(time.Now().UTC()) {
+		return memoryReset{}, false
+	}
+
+	return r, true
+}
+
+// contextError returns the error a query would return if ctx is done, so MemoryStore acts like a database.
+func contextError(ctx context.Context) error {
+	return database.QueryError(ctx, ctx.Err())
+}
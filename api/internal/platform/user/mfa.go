@@ -0,0 +1,221 @@
+package user
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/jongschneider/youtube-project/api/internal/platform/database"
+	"github.com/pkg/errors"
+)
+
+// recoveryCodeCount is how many recovery codes a user gets when they enable MFA.
+const recoveryCodeCount = 10
+
+// recoveryCodeBytes is how much randomness is in a recovery code: 80 bits, so they can't be guessed
+// even by someone with the hashes.
+const recoveryCodeBytes = 10
+
+// minRecoveryCodeKeyLength is the shortest key recovery codes can be hashed with.
+const minRecoveryCodeKeyLength = 32
+
+// ErrNoRecoveryCodeKey is the error returned when recovery codes are used before their key is configured.
+var ErrNoRecoveryCodeKey = errors.New("recovery code key not configured")
+
+// recoveryCodeKey is the server secret recovery codes are hashed with, so a copy of the db isn't enough to check guesses.
+var recoveryCodeKey []byte
+
+// ConfigureRecoveryCodes sets the secret key recovery codes are hashed with. It must be at least 32 characters.
+// Changing the key invalidates every existing recovery code.
+func ConfigureRecoveryCodes(key string) error {
+	if len(key) < minRecoveryCodeKeyLength {
+		return errors.Errorf("recovery code key must be at least %d characters", minRecoveryCodeKeyLength)
+	}
+
+	recoveryCodeKey = []byte(key)
+	return nil
+}
+
+// SetTOTPSecret starts TOTP enrollment by storing a new secret for the user.
+// MFA stays disabled until EnableTOTP is called. It returns sql.ErrNoRows if the user doesn't exist or is deleted.
+func SetTOTPSecret(ctx context.Context, db *database.DB, id int, secret string) error {
+	query := `UPDATE users SET totp_secret = ?, totp_enabled = FALSE, version = version + 1 WHERE id = ? AND deleted_at IS NULL`
+
+	res, err := db.ExecContext(ctx, query, secret, id)
+	if err != nil {
+		return err
+	}
+
+	return updatedUser(res)
+}
+
+// EnableTOTP finishes TOTP enrollment and replaces the user's recovery codes with new ones.
+// The plain text recovery codes are returned so they can be shown to the user once; only their hashes are stored.
+// It returns sql.ErrNoRows if the user doesn't exist or is deleted.
+func EnableTOTP(ctx context.Context, db *database.DB, id int) ([]string, error) {
+	codes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "begin")
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `UPDATE users SET totp_enabled = TRUE, version = version + 1 WHERE id = ? AND deleted_at IS NULL`, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "enable totp")
+	}
+
+	err = updatedUser(res)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM user_recovery_codes WHERE user_id = ?`, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "delete recovery codes")
+	}
+
+	for _, c := range codes {
+		_, err = tx.ExecContext(ctx, `INSERT INTO user_recovery_codes (user_id, code_hash) VALUES (?, ?)`, id, HashRecoveryCode(c))
+		if err != nil {
+			return nil, errors.Wrap(err, "insert recovery code")
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, errors.Wrap(err, "commit")
+	}
+
+	return codes, nil
+}
+
+// DisableTOTP turns MFA off for the user and removes their secret and recovery codes.
+// It returns sql.ErrNoRows if the user doesn't exist or is deleted.
+func DisableTOTP(ctx context.Context, db *database.DB, id int) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "begin")
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `UPDATE users SET totp_secret = '', totp_enabled = FALSE, version = version + 1 WHERE id = ? AND deleted_at IS NULL`, id)
+	if err != nil {
+		return errors.Wrap(err, "disable totp")
+	}
+
+	err = updatedUser(res)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM user_recovery_codes WHERE user_id = ?`, id)
+	if err != nil {
+		return errors.Wrap(err, "delete recovery codes")
+	}
+
+	return tx.Commit()
+}
+
+// updatedUser returns sql.ErrNoRows if an update of a user didn't change any rows, because the user doesn't exist or is deleted.
+func updatedUser(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// UseRecoveryCode marks one of the user's recovery codes as used.
+// It returns false if the code doesn't exist or has already been used.
+func UseRecoveryCode(ctx context.Context, db *database.DB, id int, code string) (bool, error) {
+	if len(recoveryCodeKey) == 0 {
+		return false, ErrNoRecoveryCodeKey
+	}
+
+	query := `UPDATE user_recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`
+
+	res, err := db.ExecContext(ctx, query, time.Now().UTC(), id, HashRecoveryCode(code))
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// UseTOTPCounter records that the user logged in with the TOTP code of the counter's period.
+// It returns false if a code from that period or a later one was already used, so every code can only be used once.
+func UseTOTPCounter(ctx context.Context, db *database.DB, id int, counter int64) (bool, error) {
+	query := `UPDATE users SET totp_last_counter = ? WHERE id = ? AND totp_last_counter < ? AND deleted_at IS NULL`
+
+	res, err := db.ExecContext(ctx, query, counter, id, counter)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// HashRecoveryCode returns the hash a recovery code is stored as.
+// Recovery codes are random, so a keyed hash is enough and lets them be looked up directly.
+func HashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	mac := hmac.New(sha256.New, recoveryCodeKey)
+	mac.Write([]byte(normalized))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newRecoveryCodes returns a full set of new recovery codes.
+func newRecoveryCodes() ([]string, error) {
+	if len(recoveryCodeKey) == 0 {
+		return nil, ErrNoRecoveryCodeKey
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		c, err := newRecoveryCode()
+		if err != nil {
+			return nil, errors.Wrap(err, "new recovery code")
+		}
+		codes = append(codes, c)
+	}
+
+	return codes, nil
+}
+
+// newRecoveryCode returns a random code formatted as xxxx-xxxx-xxxx-xxxx.
+func newRecoveryCode() (string, error) {
+	b := make([]byte, recoveryCodeBytes)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	c := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+	return strings.Join([]string{c[0:4], c[4:8], c[8:12], c[12:16]}, "-"), nil
+}
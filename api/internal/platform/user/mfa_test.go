@@ -0,0 +1,83 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+func TestTOTP(t *testing.T) {
+	ctx := context.Background()
+
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			id := insertUsers(t, s, "jane@example.com")[0]
+
+			err := s.SetTOTPSecret(ctx, id, "JBSWY3DPEHPK3PXP")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			codes, err := s.EnableTOTP(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(codes) != recoveryCodeCount {
+				t.Errorf("EnableTOTP() returned %d recovery codes, want %d", len(codes), recoveryCodeCount)
+			}
+
+			u, err := s.GetByID(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !u.TOTPEnabled || u.TOTPSecret != "JBSWY3DPEHPK3PXP" {
+				t.Errorf("after EnableTOTP() enabled = %v, secret = %q", u.TOTPEnabled, u.TOTPSecret)
+			}
+
+			err = s.DisableTOTP(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			u, err = s.GetByID(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.TOTPEnabled || u.TOTPSecret != "" {
+				t.Errorf("after DisableTOTP() enabled = %v, secret = %q", u.TOTPEnabled, u.TOTPSecret)
+			}
+		})
+	}
+}
+
+func TestTOTPMissingUser(t *testing.T) {
+	ctx := context.Background()
+
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			deleted := insertUsers(t, s, "jane@example.com")[0]
+
+			err := s.Delete(ctx, deleted, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, id := range []int{deleted, deleted + 100} {
+				err = s.SetTOTPSecret(ctx, id, "JBSWY3DPEHPK3PXP")
+				if err != sql.ErrNoRows {
+					t.Errorf("SetTOTPSecret(%d) error = %v, want %v", id, err, sql.ErrNoRows)
+				}
+
+				_, err = s.EnableTOTP(ctx, id)
+				if err != sql.ErrNoRows {
+					t.Errorf("EnableTOTP(%d) error = %v, want %v", id, err, sql.ErrNoRows)
+				}
+
+				err = s.DisableTOTP(ctx, id)
+				if err != sql.ErrNoRows {
+					t.Errorf("DisableTOTP(%d) error = %v, want %v", id, err, sql.ErrNoRows)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jongschneider/youtube-project/api/internal/platform/database"
+	"github.com/pkg/errors"
+)
+
+// Restore brings back a soft deleted user that hasn't been purged yet.
+// It returns sql.ErrNoRows if there is no such user, and ErrEmailInUse if another user has taken its email since.
+func Restore(ctx context.Context, db *database.DB, id int) error {
+	query := `UPDATE users SET deleted_at = NULL, version = version + 1 WHERE id = ? AND deleted_at IS NOT NULL`
+
+	res, err := db.ExecContext(ctx, query, id)
+	if err == database.ErrDuplicate {
+		return ErrEmailInUse
+	}
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "restore")
+	}
+
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// Purge permanently removes every user that was soft deleted before the provided time, along with their
+// recovery codes and password resets. It returns the number of users removed.
+func Purge(ctx context.Context, db *database.DB, before time.Time) (int64, error) {
+	deleted := `SELECT id FROM users WHERE deleted_at IS NOT NULL AND deleted_at < ?`
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "begin")
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM user_recovery_codes WHERE user_id IN (`+deleted+`)`, before)
+	if err != nil {
+		return 0, errors.Wrap(err, "purge recovery codes")
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM password_resets WHERE user_id IN (`+deleted+`)`, before)
+	if err != nil {
+		return 0, errors.Wrap(err, "purge password resets")
+	}
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM users WHERE deleted_at IS NOT NULL AND deleted_at < ?`, before)
+	if err != nil {
+		return 0, errors.Wrap(err, "purge users")
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "purge users")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, errors.Wrap(err, "commit")
+	}
+
+	return n, nil
+}
@@ -0,0 +1,130 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"github.com/jongschneider/youtube-project/api/internal/platform/database"
+	"github.com/jongschneider/youtube-project/api/internal/platform/encryption"
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidResetToken is the error returned when a password reset token is unknown, expired or already used.
+var ErrInvalidResetToken = errors.New("invalid reset token")
+
+// CreatePasswordReset creates a single-use password reset token for the user that expires after ttl.
+// Only a hash of the token is stored; the token itself is returned so it can be sent to the user.
+func CreatePasswordReset(ctx context.Context, db *database.DB, id int, ttl time.Duration) (string, error) {
+	query := `INSERT INTO password_resets (user_id, token_hash, expires_at) VALUES (?, ?, ?)`
+
+	token, err := newResetToken()
+	if err != nil {
+		return "", errors.Wrap(err, "create password reset")
+	}
+
+	_, err = db.ExecContext(ctx, query, id, hashResetToken(token), time.Now().UTC().Add(ttl))
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// ResetPassword sets a new password for the user the reset token was issued to and uses up the token,
+// along with every other outstanding reset token for the user.
+// It returns the user whose password was reset, ErrInvalidResetToken, or sql.ErrNoRows if the user has been deleted.
+func ResetPassword(ctx context.Context, db *database.DB, token, password string) (User, error) {
+	hash, err := encryption.Encrypt(password)
+	if err != nil {
+		return User{}, errors.Wrap(err, "reset password")
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return User{}, errors.Wrap(err, "begin")
+	}
+	defer tx.Rollback()
+
+	// SQLite doesn't have FOR UPDATE, but only lets one transaction write at a time anyway
+	query := `SELECT user_id FROM password_resets WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?`
+	if db.Dialect() != database.SQLite {
+		query += ` FOR UPDATE`
+	}
+
+	target := []int{}
+	err = tx.SelectContext(ctx, &target, query, hashResetToken(token), time.Now().UTC())
+	if err != nil {
+		return User{}, errors.Wrap(err, "get password reset")
+	}
+
+	if len(target) == 0 {
+		return User{}, ErrInvalidResetToken
+	}
+	id := target[0]
+
+	res, err := tx.ExecContext(ctx, `UPDATE users SET password = ?, version = version + 1 WHERE id = ? AND deleted_at IS NULL`, hash, id)
+	if err != nil {
+		return User{}, errors.Wrap(err, "update password")
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return User{}, errors.Wrap(err, "update password")
+	}
+
+	// Deleted users keep their tokens, but can't use them until they are restored
+	if n == 0 {
+		return User{}, sql.ErrNoRows
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE password_resets SET used_at = ? WHERE user_id = ? AND used_at IS NULL`, time.Now().UTC(), id)
+	if err != nil {
+		return User{}, errors.Wrap(err, "use password resets")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return User{}, errors.Wrap(err, "commit")
+	}
+
+	return GetByID(ctx, db, id)
+}
+
+// GetByResetToken gets the user an outstanding password reset token was issued to.
+// It returns ErrInvalidResetToken if the token is unknown, expired or already used.
+func GetByResetToken(ctx context.Context, db *database.DB, token string) (User, error) {
+	query := `SELECT user_id FROM password_resets WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?`
+
+	target := []int{}
+	err := db.SelectContext(ctx, &target, query, hashResetToken(token), time.Now().UTC())
+	if err != nil {
+		return User{}, err
+	}
+
+	if len(target) == 0 {
+		return User{}, ErrInvalidResetToken
+	}
+
+	return GetByID(ctx, db, target[0])
+}
+
+// newResetToken returns a random password reset token.
+func newResetToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
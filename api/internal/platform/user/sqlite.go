@@ -0,0 +1,39 @@
+package user
+
+import (
+	"time"
+
+	"github.com/jongschneider/youtube-project/api/internal/platform/database"
+	"github.com/jongschneider/youtube-project/api/internal/platform/migrate"
+	"github.com/jongschneider/youtube-project/api/internal/schema"
+	"github.com/pkg/errors"
+	_ "modernc.org/sqlite" // provides the pure go sqlite driver for sqlx
+)
+
+// NewSQLiteStore returns a Store backed by the SQLite database in the file at path, applying the sqlite migrations it hasn't had yet.
+// A path of ":memory:" keeps the database in memory until the store is closed.
+// Queries are given up on after queryTimeout. 0 means they never time out.
+func NewSQLiteStore(path string, queryTimeout time.Duration) (*SQLStore, error) {
+	// Times are stored in a format that sorts and compares the same way as the times themselves
+	db, err := database.Open(database.SQLite, "file:"+path+"?_time_format=sqlite", queryTimeout)
+	if err != nil {
+		return nil, errors.Wrap(err, "open sqlite")
+	}
+
+	// SQLite only allows one writer at a time, and every connection to :memory: is a different database
+	db.SetMaxOpenConns(1)
+
+	m, err := migrate.New(db, migrate.Config{Dir: "migrations", FS: schema.Migrations})
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "load migrations")
+	}
+
+	_, err = m.Up()
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "migrate")
+	}
+
+	return NewSQLStore(db), nil
+}
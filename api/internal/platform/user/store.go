@@ -0,0 +1,163 @@
+package user
+
+import (
+	"context"
+	"time"
+
+	"github.com/jongschneider/youtube-project/api/internal/platform/database"
+)
+
+// Store stores users. Every implementation returns sql.ErrNoRows for a user that doesn't exist or was deleted,
+// ErrVersionMismatch for a conditional write to a user that has changed, ErrEmailInUse for a write that would give
+// a user the email of another user that hasn't been deleted, and database.ErrTimeout or database.ErrCanceled
+// when ctx is done before it finishes. Every implementation is safe for concurrent use.
+type Store interface {
+	// GetByEmail gets the user with the email.
+	GetByEmail(ctx context.Context, email string) (User, error)
+
+	// GetByID gets the user with the id.
+	GetByID(ctx context.Context, id int) (User, error)
+
+	// List gets a page of users matching the options.
+	List(ctx context.Context, o ListOptions) (Page, error)
+
+	// Insert creates a new user from its email and password, and returns its ID.
+	Insert(ctx context.Context, u User) (int, error)
+
+	// Update changes the fields of the user that are set in c.
+	// If version isn't 0 the user is only updated if it is still at that version.
+	Update(ctx context.Context, id, version int, c Changes) error
+
+	// Delete soft deletes the user.
+	// If version isn't 0 the user is only deleted if it is still at that version.
+	Delete(ctx context.Context, id, version int) error
+
+	// Restore brings back a soft deleted user that hasn't been purged yet.
+	Restore(ctx context.Context, id int) error
+
+	// VerifyEmail marks the user's email as verified, as long as it is still the email the verification was sent to.
+	// It returns ErrEmailChanged if it isn't.
+	VerifyEmail(ctx context.Context, id int, email string) error
+
+	// SetTOTPSecret starts TOTP enrollment by storing a new secret for the user.
+	SetTOTPSecret(ctx context.Context, id int, secret string) error
+
+	// EnableTOTP finishes TOTP enrollment and returns the user's new recovery codes.
+	EnableTOTP(ctx context.Context, id int) ([]string, error)
+
+	// DisableTOTP turns MFA off for the user and removes their secret and recovery codes.
+	// SetTOTPSecret, EnableTOTP and DisableTOTP return sql.ErrNoRows if the user doesn't exist or is deleted.
+	DisableTOTP(ctx context.Context, id int) error
+
+	// UseRecoveryCode marks one of the user's recovery codes as used, returning false if it can't be used.
+	UseRecoveryCode(ctx context.Context, id int, code string) (bool, error)
+
+	// UseTOTPCounter records the counter of the TOTP code the user logged in with, returning false if it was already used.
+	UseTOTPCounter(ctx context.Context, id int, counter int64) (bool, error)
+
+	// CreatePasswordReset creates a single-use password reset token for the user that expires after ttl.
+	CreatePasswordReset(ctx context.Context, id int, ttl time.Duration) (string, error)
+
+	// GetByResetToken gets the user an outstanding password reset token was issued to.
+	// It returns ErrInvalidResetToken if there is no such token.
+	GetByResetToken(ctx context.Context, token string) (User, error)
+
+	// ResetPassword sets a new password for the user the reset token was issued to and uses up their reset tokens.
+	// It returns ErrInvalidResetToken if there is no such token, or sql.ErrNoRows if the user has been deleted.
+	ResetPassword(ctx context.Context, token, password string) (User, error)
+}
+
+// SQLStore is a Store backed by a SQL database, such as MySQL.
+type SQLStore struct {
+	db *database.DB
+}
+
+// NewSQLStore returns a Store backed by the db.
+func NewSQLStore(db *database.DB) *SQLStore {
+	return &SQLStore{db: db}
+}
+
+// GetByEmail gets the user with the email.
+func (s *SQLStore) GetByEmail(ctx context.Context, email string) (User, error) {
+	return GetByEmail(ctx, s.db, email)
+}
+
+// GetByID gets the user with the id.
+func (s *SQLStore) GetByID(ctx context.Context, id int) (User, error) {
+	return GetByID(ctx, s.db, id)
+}
+
+// List gets a page of users matching the options.
+func (s *SQLStore) List(ctx context.Context, o ListOptions) (Page, error) {
+	return List(ctx, s.db, o)
+}
+
+// Insert creates a new user from its email and password, and returns its ID.
+func (s *SQLStore) Insert(ctx context.Context, u User) (int, error) {
+	return Insert(ctx, s.db, u)
+}
+
+// Update changes the fields of the user that are set in c.
+func (s *SQLStore) Update(ctx context.Context, id, version int, c Changes) error {
+	return UpdateFields(ctx, s.db, id, version, c)
+}
+
+// Delete soft deletes the user.
+func (s *SQLStore) Delete(ctx context.Context, id, version int) error {
+	return Delete(ctx, s.db, id, version)
+}
+
+// Restore brings back a soft deleted user that hasn't been purged yet.
+func (s *SQLStore) Restore(ctx context.Context, id int) error {
+	return Restore(ctx, s.db, id)
+}
+
+// VerifyEmail marks the user's email as verified, as long as it is still the email the verification was sent to.
+func (s *SQLStore) VerifyEmail(ctx context.Context, id int, email string) error {
+	return VerifyEmail(ctx, s.db, id, email)
+}
+
+// SetTOTPSecret starts TOTP enrollment by storing a new secret for the user.
+func (s *SQLStore) SetTOTPSecret(ctx context.Context, id int, secret string) error {
+	return SetTOTPSecret(ctx, s.db, id, secret)
+}
+
+// EnableTOTP finishes TOTP enrollment and returns the user's new recovery codes.
+func (s *SQLStore) EnableTOTP(ctx context.Context, id int) ([]string, error) {
+	return EnableTOTP(ctx, s.db, id)
+}
+
+// DisableTOTP turns MFA off for the user and removes their secret and recovery codes.
+func (s *SQLStore) DisableTOTP(ctx context.Context, id int) error {
+	return DisableTOTP(ctx, s.db, id)
+}
+
+// UseRecoveryCode marks one of the user's recovery codes as used, returning false if it can't be used.
+func (s *SQLStore) UseRecoveryCode(ctx context.Context, id int, code string) (bool, error) {
+	return UseRecoveryCode(ctx, s.db, id, code)
+}
+
+// UseTOTPCounter records the counter of the TOTP code the user logged in with, returning false if it was already used.
+func (s *SQLStore) UseTOTPCounter(ctx context.Context, id int, counter int64) (bool, error) {
+	return UseTOTPCounter(ctx, s.db, id, counter)
+}
+
+// CreatePasswordReset creates a single-use password reset token for the user that expires after ttl.
+func (s *SQLStore) CreatePasswordReset(ctx context.Context, id int, ttl time.Duration) (string, error) {
+	return CreatePasswordReset(ctx, s.db, id, ttl)
+}
+
+// GetByResetToken gets the user an outstanding password reset token was issued to.
+func (s *SQLStore) GetByResetToken(ctx context.Context, token string) (User, error) {
+	return GetByResetToken(ctx, s.db, token)
+}
+
+// ResetPassword sets a new password for the user the reset token was issued to and uses up their reset tokens.
+func (s *SQLStore) ResetPassword(ctx context.Context, token, password string) (User, error) {
+	return ResetPassword(ctx, s.db, token, password)
+}
+
+// Close closes the db.
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
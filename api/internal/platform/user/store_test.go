@@ -0,0 +1,59 @@
+package user
+
+import (
+	"context"
+	"testing"
+)
+
+func TestEmailInUse(t *testing.T) {
+	ctx := context.Background()
+
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ids := insertUsers(t, s, "jane@example.com", "john@example.com")
+
+			_, err := s.Insert(ctx, User{Email: "jane@example.com", Password: "Correct-Horse-1"})
+			if err != ErrEmailInUse {
+				t.Errorf("insert: err = %v, want %v", err, ErrEmailInUse)
+			}
+
+			taken := "jane@example.com"
+			err = s.Update(ctx, ids[1], 0, Changes{Email: &taken})
+			if err != ErrEmailInUse {
+				t.Errorf("update: err = %v, want %v", err, ErrEmailInUse)
+			}
+
+			// Keeping the same email isn't a conflict with itself
+			err = s.Update(ctx, ids[0], 0, Changes{Email: &taken})
+			if err != nil {
+				t.Errorf("update to own email: %v", err)
+			}
+
+			// A deleted user's email can be used again, but then the deleted user can't be restored
+			err = s.Delete(ctx, ids[0], 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			id, err := s.Insert(ctx, User{Email: "jane@example.com", Password: "Correct-Horse-1"})
+			if err != nil {
+				t.Fatalf("insert with a deleted user's email: %v", err)
+			}
+
+			err = s.Restore(ctx, ids[0])
+			if err != ErrEmailInUse {
+				t.Errorf("restore: err = %v, want %v", err, ErrEmailInUse)
+			}
+
+			err = s.Delete(ctx, id, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = s.Restore(ctx, ids[0])
+			if err != nil {
+				t.Errorf("restore once the email is free: %v", err)
+			}
+		})
+	}
+}
@@ -1,27 +1,91 @@
 package user
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/jongschneider/youtube-project/api/internal/platform/database"
 	"github.com/jongschneider/youtube-project/api/internal/platform/encryption"
 	"github.com/pkg/errors"
 )
 
+// Roles a user can have. A user's role determines the scopes they are granted.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// Scopes that can be granted to a user.
+const (
+	ScopeUsersRead  = "users:read"
+	ScopeUsersWrite = "users:write"
+
+	// ScopeMetricsRead lets the holder read the API's metrics, such as the db's connection pool.
+	ScopeMetricsRead = "metrics:read"
+)
+
+// roleScopes maps each role to the scopes it grants.
+var roleScopes = map[string][]string{
+	RoleUser:  {ScopeUsersRead},
+	RoleAdmin: {ScopeUsersRead, ScopeUsersWrite, ScopeMetricsRead},
+}
+
+// ErrVersionMismatch is the error returned by a conditional write when the user has been changed since the version it was conditional on.
+var ErrVersionMismatch = errors.New("version mismatch")
+
+// ErrEmailInUse is the error returned when a user would get the email of another user that hasn't been deleted.
+var ErrEmailInUse = errors.New("email is already in use")
+
+// ErrInvalidRole is the error returned when a user is given a role that doesn't exist.
+var ErrInvalidRole = errors.New("invalid role")
+
+// ValidRole returns true if the role exists.
+func ValidRole(role string) bool {
+	_, ok := roleScopes[role]
+	return ok
+}
+
 // User represents a user in the DB
 type User struct {
 	ID       int    `json:"id" db:"id"`
 	Password string `json:"-" db:"password"`
 	Email    string `json:"email" db:"email"`
+	Role     string `json:"role" db:"role"`
+
+	// TOTPSecret is the user's TOTP secret. It is set as soon as enrollment starts,
+	// but MFA isn't required until TOTPEnabled is set by confirming a code.
+	TOTPSecret  string `json:"-" db:"totp_secret"`
+	TOTPEnabled bool   `json:"mfa_enabled" db:"totp_enabled"`
+
+	// EmailVerifiedAt is when the user last proved they own their email. It is nil until then.
+	EmailVerifiedAt *time.Time `json:"email_verified_at" db:"email_verified_at"`
+
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+
+	// Version goes up every time the user is changed, so writes can be made conditional on it.
+	Version int `json:"-" db:"version"`
+}
+
+// EmailVerified returns true if the user has verified their current email.
+func (u User) EmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+// Scopes returns the scopes granted to the user by their role.
+func (u User) Scopes() []string {
+	return roleScopes[u.Role]
 }
 
 // GetByEmail gets a user associated with the provided email
-func GetByEmail(db *database.DB, email string) (User, error) {
-	query := `SELECT id, password, email FROM users WHERE email = ?`
+func GetByEmail(ctx context.Context, db *database.DB, email string) (User, error) {
+	query := `SELECT id, password, email, role, totp_secret, totp_enabled, email_verified_at, version, created_at FROM users WHERE email = ? AND deleted_at IS NULL`
 
 	target := []User{}
 
-	err := db.Select(&target, query, email)
+	err := db.SelectContext(ctx, &target, query, email)
 	if err != nil {
 		return User{}, err
 	}
@@ -34,12 +98,12 @@ func GetByEmail(db *database.DB, email string) (User, error) {
 }
 
 // GetByID gets gets a user associated with the provided id
-func GetByID(db *database.DB, id int) (User, error) {
-	query := `SELECT id, password, email FROM users WHERE id = ?`
+func GetByID(ctx context.Context, db *database.DB, id int) (User, error) {
+	query := `SELECT id, password, email, role, totp_secret, totp_enabled, email_verified_at, version, created_at FROM users WHERE id = ? AND deleted_at IS NULL`
 
 	target := []User{}
 
-	err := db.Select(&target, query, id)
+	err := db.SelectContext(ctx, &target, query, id)
 	if err != nil {
 		return User{}, err
 	}
@@ -51,64 +115,143 @@ func GetByID(db *database.DB, id int) (User, error) {
 	return target[0], nil
 }
 
-// GetAll gets gets a user associated with the provided id
-func GetAll(db *database.DB) ([]User, error) {
-	query := `SELECT id, password, email FROM users`
+// Delete soft deletes a user. The user can't be looked up anymore, but can be restored until it is purged.
+// If version isn't 0 the user is only deleted if it is still at that version, otherwise ErrVersionMismatch is returned.
+func Delete(ctx context.Context, db *database.DB, id, version int) error {
+	query := `UPDATE users SET deleted_at = ?, version = version + 1 WHERE id = ? AND deleted_at IS NULL`
+	args := []interface{}{time.Now().UTC(), id}
 
-	target := []User{}
+	return conditionalExec(ctx, db, id, version, query, args)
+}
+
+// Insert creates a new user and returns its ID.
+// It returns ErrEmailInUse if another user that hasn't been deleted has the email.
+func Insert(ctx context.Context, db *database.DB, u User) (int, error) {
+	query := `INSERT INTO users (email, password, created_at) VALUES ( ?, ?, ? )`
 
-	err := db.Select(&target, query)
+	hash, err := encryption.Encrypt(u.Password)
 	if err != nil {
-		return target, err
+		return 0, errors.Wrap(err, "insert")
 	}
+	createdAt := time.Now().UTC()
 
-	if len(target) == 0 {
-		return target, sql.ErrNoRows
+	// Postgres drivers don't support LastInsertId, so the id has to be returned by the insert itself
+	if db.Dialect() == database.Postgres {
+		id := 0
+		err = db.GetContext(ctx, &id, query+" RETURNING id", u.Email, hash, createdAt)
+		if err == database.ErrDuplicate {
+			return 0, ErrEmailInUse
+		}
+		if err != nil {
+			return 0, err
+		}
+		return id, nil
 	}
 
-	return target, nil
-}
-
-// Delete deletes a user from the user table
-func Delete(db *database.DB, id int) error {
-	query := `DELETE FROM users WHERE id = ?`
+	res, err := db.ExecContext(ctx, query, u.Email, hash, createdAt)
+	if err == database.ErrDuplicate {
+		return 0, ErrEmailInUse
+	}
+	if err != nil {
+		return 0, err
+	}
 
-	_, err := db.Exec(query, id)
+	id, err := res.LastInsertId()
 	if err != nil {
-		return err
+		return 0, errors.Wrap(err, "insert")
 	}
 
-	return nil
+	return int(id), nil
 }
 
-// Insert creates a new user.
-func Insert(db *database.DB, u User) error {
-	query := `INSERT INTO users (email, password) VALUE ( ?, ? )`
+// Changes holds the fields to change on a user. Fields that are nil are left as they are.
+type Changes struct {
+	Email    *string
+	Password *string
+	Role     *string
+}
 
-	hash, err := encryption.Encrypt(u.Password)
-	if err != nil {
-		return errors.Wrap(err, "insert")
+// UpdateFields updates only the columns of the provided changes.
+// Changing the email clears its verification, since the new address hasn't been verified.
+// If version isn't 0 the user is only updated if it is still at that version, otherwise ErrVersionMismatch is returned.
+// It returns ErrEmailInUse if another user that hasn't been deleted has the new email.
+func UpdateFields(ctx context.Context, db *database.DB, id, version int, c Changes) error {
+	sets := []string{}
+	args := []interface{}{}
+
+	if c.Email != nil {
+		// email_verified_at has to be set first so it is compared against the old email
+		sets = append(sets, "email_verified_at = CASE WHEN email = ? THEN email_verified_at ELSE NULL END", "email = ?")
+		args = append(args, *c.Email, *c.Email)
 	}
-	_, err = db.Exec(query, u.Email, hash)
-	if err != nil {
-		return err
+
+	if c.Password != nil {
+		hash, err := encryption.Encrypt(*c.Password)
+		if err != nil {
+			return errors.Wrap(err, "update")
+		}
+		sets = append(sets, "password = ?")
+		args = append(args, hash)
 	}
 
-	return nil
+	if c.Role != nil {
+		if !ValidRole(*c.Role) {
+			return ErrInvalidRole
+		}
+		sets = append(sets, "role = ?")
+		args = append(args, *c.Role)
+	}
+
+	// Nothing to change
+	if len(sets) == 0 {
+		return nil
+	}
+
+	sets = append(sets, "version = version + 1")
+
+	query := fmt.Sprintf(`UPDATE users SET %s WHERE id = ? AND deleted_at IS NULL`, strings.Join(sets, ", "))
+	args = append(args, id)
+
+	err := conditionalExec(ctx, db, id, version, query, args)
+	if err == database.ErrDuplicate {
+		return ErrEmailInUse
+	}
+
+	return err
 }
 
-// Update updates an existing user.
-func Update(db *database.DB, u User) error {
-	query := `UPDATE users SET email = ?, password = ? WHERE id = ?`
+// conditionalExec runs an UPDATE of the user with the provided id, adding a condition on the version if it isn't 0.
+// The update must increment the version, so a user that matched is always reported as affected.
+// It returns sql.ErrNoRows if the user doesn't exist and ErrVersionMismatch if it is at a different version.
+func conditionalExec(ctx context.Context, db *database.DB, id, version int, query string, args []interface{}) error {
+	if version != 0 {
+		query += " AND version = ?"
+		args = append(args, version)
+	}
 
-	hash, err := encryption.Encrypt(u.Password)
+	res, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
 	if err != nil {
-		return errors.Wrap(err, "update")
+		return errors.Wrap(err, "rows affected")
+	}
+
+	if n > 0 {
+		return nil
 	}
-	_, err = db.Exec(query, u.Email, hash, u.ID)
+
+	// Find out why nothing was updated
+	_, err = GetByID(ctx, db, id)
 	if err != nil {
 		return err
 	}
 
+	if version != 0 {
+		return ErrVersionMismatch
+	}
+
 	return nil
 }
@@ -0,0 +1,42 @@
+package user
+
+import (
+	"context"
+	"time"
+
+	"github.com/jongschneider/youtube-project/api/internal/platform/database"
+	"github.com/pkg/errors"
+)
+
+// ErrEmailChanged is the error returned when a verification link was sent to an email the user no longer has.
+var ErrEmailChanged = errors.New("email changed")
+
+// VerifyEmail marks the user's email as verified, as long as it is still the email the verification was sent to.
+// Verifying an email that is already verified keeps the original timestamp.
+func VerifyEmail(ctx context.Context, db *database.DB, id int, email string) error {
+	query := `UPDATE users SET email_verified_at = COALESCE(email_verified_at, ?), version = version + 1 WHERE id = ? AND email = ? AND deleted_at IS NULL`
+
+	res, err := db.ExecContext(ctx, query, time.Now().UTC(), id, email)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "verify email")
+	}
+
+	// Nothing was updated, so check whether the user still has the email
+	if n == 0 {
+		u, err := GetByID(ctx, db, id)
+		if err != nil {
+			return err
+		}
+
+		if u.Email != email {
+			return ErrEmailChanged
+		}
+	}
+
+	return nil
+}
@@ -27,3 +27,27 @@ func RespondWithCodedError(w http.ResponseWriter, r *http.Request, httpStatus in
 		ErrorCode: code,
 	})
 }
+
+type detailedErrResponse struct {
+	errResponse
+	Details interface{} `json:"details,omitempty"`
+}
+
+// RespondWithDetailedError responds with error JSON along with details about what was wrong with the request
+func RespondWithDetailedError(w http.ResponseWriter, r *http.Request, httpStatus int, code string, details interface{}, err error) {
+	serr := http.StatusText(httpStatus)
+
+	if ct := w.Header().Get("Content-Type"); ct == "" {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	}
+
+	w.WriteHeader(httpStatus)
+	render.JSON(w, r, detailedErrResponse{
+		errResponse: errResponse{
+			Error:     serr,
+			Status:    httpStatus,
+			ErrorCode: code,
+		},
+		Details: details,
+	})
+}
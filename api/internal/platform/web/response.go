@@ -18,6 +18,7 @@ func Respond(w http.ResponseWriter, r *http.Request, data interface{}, statusCod
 		return nil
 	}
 
+	render.Status(r, statusCode)
 	render.JSON(w, r, data)
 
 	return nil
@@ -0,0 +1,33 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRespondStatus(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+	}{
+		{http.StatusOK, "{\"message\":\"ok\"}\n"},
+		{http.StatusCreated, "{\"message\":\"ok\"}\n"},
+		{http.StatusAccepted, "{\"message\":\"ok\"}\n"},
+		{http.StatusNoContent, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			w := httptest.NewRecorder()
+			Respond(w, httptest.NewRequest(http.MethodGet, "/", nil), Response{Message: "ok"}, tt.status)
+
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if w.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", w.Body, tt.body)
+			}
+		})
+	}
+}
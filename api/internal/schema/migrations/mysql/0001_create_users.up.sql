@@ -0,0 +1,25 @@
+ALTER DATABASE
+    DEFAULT CHARACTER SET utf8
+    DEFAULT COLLATE utf8_general_ci;
+
+-- Databases created before migrations were added already have a users table in this shape, from the old init.sql.
+-- The table is only created if it doesn't exist, and then brought up to date the same way either way.
+CREATE TABLE IF NOT EXISTS `users` (
+    `id` INT(11) UNSIGNED NOT NULL AUTO_INCREMENT,
+    `email` VARCHAR(100) NOT NULL DEFAULT '',
+    `password` VARCHAR(100) NOT NULL DEFAULT '',
+    PRIMARY KEY (`id`)
+) ENGINE=InnoDB CHARSET=utf8;
+
+ALTER TABLE `users`
+    MODIFY `password` VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN `role` VARCHAR(50) NOT NULL DEFAULT 'user',
+    ADD COLUMN `totp_secret` VARCHAR(64) NOT NULL DEFAULT '',
+    ADD COLUMN `totp_enabled` TINYINT(1) NOT NULL DEFAULT 0,
+    ADD COLUMN `email_verified_at` DATETIME NULL DEFAULT NULL,
+    ADD COLUMN `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    ADD COLUMN `deleted_at` DATETIME NULL DEFAULT NULL,
+    ADD COLUMN `version` INT(11) UNSIGNED NOT NULL DEFAULT 1,
+    ADD KEY `users_email` (`email`),
+    ADD KEY `users_created_at` (`created_at`),
+    ADD KEY `users_deleted_at` (`deleted_at`);
@@ -0,0 +1,8 @@
+CREATE TABLE `user_recovery_codes` (
+    `id` INT(11) UNSIGNED NOT NULL AUTO_INCREMENT,
+    `user_id` INT(11) UNSIGNED NOT NULL,
+    `code_hash` CHAR(64) NOT NULL,
+    `used_at` DATETIME NULL DEFAULT NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `user_code` (`user_id`, `code_hash`)
+) ENGINE=InnoDB CHARSET=utf8;
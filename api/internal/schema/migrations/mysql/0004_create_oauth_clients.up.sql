@@ -0,0 +1,6 @@
+CREATE TABLE `oauth_clients` (
+    `id` VARCHAR(100) NOT NULL,
+    `secret` VARCHAR(100) NOT NULL DEFAULT '',
+    `scopes` VARCHAR(255) NOT NULL DEFAULT '',
+    PRIMARY KEY (`id`)
+) ENGINE=InnoDB CHARSET=utf8;
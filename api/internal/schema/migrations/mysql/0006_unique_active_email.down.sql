@@ -0,0 +1,3 @@
+ALTER TABLE `users`
+    DROP KEY `users_active_email`,
+    DROP COLUMN `active_email`;
@@ -0,0 +1,5 @@
+-- MySQL has no partial indexes, so the email of a user that hasn't been deleted is copied into a column that is
+-- NULL for deleted users, which a unique index ignores. Users that share an email have to be resolved first.
+ALTER TABLE `users`
+    ADD COLUMN `active_email` VARCHAR(100) AS (IF(`deleted_at` IS NULL, `email`, NULL)) STORED,
+    ADD UNIQUE KEY `users_active_email` (`active_email`);
@@ -0,0 +1,16 @@
+CREATE TABLE users (
+    id SERIAL PRIMARY KEY,
+    email VARCHAR(100) NOT NULL DEFAULT '',
+    password VARCHAR(255) NOT NULL DEFAULT '',
+    role VARCHAR(50) NOT NULL DEFAULT 'user',
+    totp_secret VARCHAR(64) NOT NULL DEFAULT '',
+    totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    email_verified_at TIMESTAMP NULL DEFAULT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    deleted_at TIMESTAMP NULL DEFAULT NULL,
+    version INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE INDEX users_email ON users (email);
+CREATE INDEX users_created_at ON users (created_at);
+CREATE INDEX users_deleted_at ON users (deleted_at);
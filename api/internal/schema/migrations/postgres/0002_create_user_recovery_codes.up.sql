@@ -0,0 +1,7 @@
+CREATE TABLE user_recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    code_hash CHAR(64) NOT NULL,
+    used_at TIMESTAMP NULL DEFAULT NULL,
+    CONSTRAINT user_code UNIQUE (user_id, code_hash)
+);
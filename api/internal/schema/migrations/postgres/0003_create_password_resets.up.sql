@@ -0,0 +1,10 @@
+CREATE TABLE password_resets (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    token_hash CHAR(64) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP NULL DEFAULT NULL,
+    CONSTRAINT token_hash UNIQUE (token_hash)
+);
+
+CREATE INDEX password_resets_user_id ON password_resets (user_id);
@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN totp_last_counter BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+-- Users that share an email have to be resolved first.
+CREATE UNIQUE INDEX users_active_email ON users (email) WHERE deleted_at IS NULL;
@@ -0,0 +1,16 @@
+CREATE TABLE users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    email VARCHAR(100) NOT NULL DEFAULT '',
+    password VARCHAR(255) NOT NULL DEFAULT '',
+    role VARCHAR(50) NOT NULL DEFAULT 'user',
+    totp_secret VARCHAR(64) NOT NULL DEFAULT '',
+    totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    email_verified_at DATETIME NULL DEFAULT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    deleted_at DATETIME NULL DEFAULT NULL,
+    version INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE INDEX users_email ON users (email);
+CREATE INDEX users_created_at ON users (created_at);
+CREATE INDEX users_deleted_at ON users (deleted_at);
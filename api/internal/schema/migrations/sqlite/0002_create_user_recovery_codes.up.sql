@@ -0,0 +1,7 @@
+CREATE TABLE user_recovery_codes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    code_hash CHAR(64) NOT NULL,
+    used_at DATETIME NULL DEFAULT NULL,
+    CONSTRAINT user_code UNIQUE (user_id, code_hash)
+);
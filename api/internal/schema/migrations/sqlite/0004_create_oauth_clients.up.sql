@@ -0,0 +1,5 @@
+CREATE TABLE oauth_clients (
+    id VARCHAR(100) PRIMARY KEY,
+    secret VARCHAR(100) NOT NULL DEFAULT '',
+    scopes VARCHAR(255) NOT NULL DEFAULT ''
+);
@@ -0,0 +1,10 @@
+// Package schema embeds the migrations of every dialect, so they can be applied without knowing where the files are,
+// like from the tests of other packages.
+package schema
+
+import "embed"
+
+// Migrations holds the migrations directory.
+//
+//go:embed migrations
+var Migrations embed.FS
@@ -0,0 +1,4 @@
+INSERT INTO users (email, password, role, email_verified_at)
+SELECT 'test@test.com', '$2a$10$eNxD0bfWdeWw3o3gLGVjNeiw/H0/KVaz6wh/UkFmKHm2ZJXOhvvVW', 'admin', NOW()
+FROM DUAL
+WHERE NOT EXISTS (SELECT 1 FROM users WHERE email = 'test@test.com');
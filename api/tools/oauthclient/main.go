@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/jongschneider/youtube-project/api/internal/platform/oauth"
+)
+
+// Generates a secret for an OAuth client and prints the SQL that registers it, e.g.
+//
+//	go run ./tools/oauthclient reporting-service users:read
+func main() {
+	if len(os.Args) < 2 {
+		log.Fatal("usage: oauthclient <client id> [scope...]")
+	}
+
+	id := os.Args[1]
+	scopes := strings.Join(os.Args[2:], " ")
+
+	secret, hash, err := oauth.NewSecret()
+	if err != nil {
+		panic(err)
+	}
+
+	log.Println(fmt.Sprintf("\tclient_id:      %s", id))
+	log.Println(fmt.Sprintf("\tclient_secret:  %s", secret))
+	log.Println(fmt.Sprintf("\tINSERT INTO oauth_clients (id, secret, scopes) VALUES ('%s', '%s', '%s');", id, hash, scopes))
+}